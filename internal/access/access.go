@@ -0,0 +1,99 @@
+/*
+Package access implements ordered allow and deny rules for client IPs.
+*/
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type logEntry struct {
+	ID       string
+	Time     time.Time
+	Stream   string
+	RemoteIP string
+	Rule     string
+	Allowed  bool
+}
+
+// Rule allows or denies access for a network.
+// A nil Network matches all clients.
+type Rule struct {
+	Allow   bool
+	Network *net.IPNet
+	Text    string
+}
+
+// Rules is an ordered list of access rules, where the first matching rule
+// decides. Access is allowed if no rule matches.
+type Rules []Rule
+
+func parseNetwork(value string) (*net.IPNet, error) {
+	if value == "all" {
+		return nil, nil
+	}
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP: %s", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// Parse creates Rules from strings in the format "allow|deny CIDR|IP|all",
+// e.g. "allow 10.0.0.0/8" or "deny all".
+func Parse(rules []string) (Rules, error) {
+	parsed := make(Rules, len(rules))
+	for i, text := range rules {
+		fields := strings.Fields(text)
+		if len(fields) != 2 || (fields[0] != "allow" && fields[0] != "deny") {
+			return nil, fmt.Errorf("invalid access rule: %s", text)
+		}
+		network, err := parseNetwork(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid access rule: %s", text)
+		}
+		parsed[i] = Rule{fields[0] == "allow", network, strings.Join(fields, " ")}
+	}
+	return parsed, nil
+}
+
+// Check returns whether access is allowed for the given IP and the text of the
+// matching rule, which is empty if no rule matches.
+func (r Rules) Check(ip string) (allowed bool, rule string) {
+	parsed := net.ParseIP(ip)
+	for _, entry := range r {
+		// Clients without IP, e.g. via Unix socket, are only matched by "all".
+		if entry.Network == nil ||
+			(parsed != nil && entry.Network.Contains(parsed)) {
+			return entry.Allow, entry.Text
+		}
+	}
+	return true, ""
+}
+
+// Log prints the access decision for the given request ID as JSON to STDOUT.
+func Log(id string, stream string, ip string, allowed bool, rule string) {
+	entry := &logEntry{
+		ID:       id,
+		Time:     time.Now().UTC(),
+		Stream:   stream,
+		RemoteIP: ip,
+		Rule:     rule,
+		Allowed:  allowed,
+	}
+	b, _ := json.Marshal(entry)
+	fmt.Println(string(b))
+}
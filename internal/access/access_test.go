@@ -0,0 +1,116 @@
+package access
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func outputHelper(fn func()) (stdout []byte, stderr []byte) {
+	outReader, outWriter, _ := os.Pipe()
+	errReader, errWriter, _ := os.Pipe()
+	originalOut := os.Stdout
+	originalErr := os.Stderr
+	os.Stdout = outWriter
+	os.Stderr = errWriter
+	fn()
+	outWriter.Close()
+	errWriter.Close()
+	stdout, _ = ioutil.ReadAll(outReader)
+	stderr, _ = ioutil.ReadAll(errReader)
+	os.Stdout = originalOut
+	os.Stderr = originalErr
+	return
+}
+
+func TestParse(t *testing.T) {
+	for _, rule := range []string{
+		"allow",
+		"permit 10.0.0.0/8",
+		"deny banana",
+		"deny 10.0.0.0/33",
+		"allow 10.0.0.1 10.0.0.2",
+	} {
+		_, err := Parse([]string{rule})
+		if err == nil {
+			t.Errorf("Unexpected nil error for rule: %s", rule)
+		}
+	}
+	rules, err := Parse([]string{"allow  10.0.0.0/8", "deny all"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if rules[0].Text != "allow 10.0.0.0/8" {
+		t.Errorf(
+			"Unexpected rule text: %s. Expected: %s",
+			rules[0].Text,
+			"allow 10.0.0.0/8",
+		)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rules, _ := Parse([]string{
+		"deny 10.0.0.1",
+		"allow 10.0.0.0/8",
+		"allow 2001:db8::/32",
+		"deny all",
+	})
+	tests := []struct {
+		ip      string
+		allowed bool
+		rule    string
+	}{
+		{"10.0.0.1", false, "deny 10.0.0.1"},
+		{"10.0.0.2", true, "allow 10.0.0.0/8"},
+		{"2001:db8::1", true, "allow 2001:db8::/32"},
+		{"192.0.2.1", false, "deny all"},
+		{"", false, "deny all"},
+	}
+	for _, test := range tests {
+		allowed, rule := rules.Check(test.ip)
+		if allowed != test.allowed {
+			t.Errorf(
+				"Unexpected result for %s: %t. Expected: %t",
+				test.ip,
+				allowed,
+				test.allowed,
+			)
+		}
+		if rule != test.rule {
+			t.Errorf("Unexpected rule: %s. Expected: %s", rule, test.rule)
+		}
+	}
+	allowed, rule := Rules(nil).Check("192.0.2.1")
+	if !allowed || rule != "" {
+		t.Errorf("Unexpected result without rules: %t, %s", allowed, rule)
+	}
+}
+
+func TestLog(t *testing.T) {
+	stdout, stderr := outputHelper(func() {
+		Log("1", "/", "192.0.2.1", false, "deny all")
+	})
+	if string(stderr) != "" {
+		t.Errorf("Unexpected stderr: %s", stderr)
+	}
+	var entry logEntry
+	json.Unmarshal(stdout, &entry)
+	if entry.ID != "1" {
+		t.Errorf("Unexpected 'ID' log: %s. Expected: %s", entry.ID, "1")
+	}
+	if entry.RemoteIP != "192.0.2.1" {
+		t.Errorf(
+			"Unexpected 'RemoteIP' log: %s. Expected: %s",
+			entry.RemoteIP,
+			"192.0.2.1",
+		)
+	}
+	if entry.Rule != "deny all" {
+		t.Errorf("Unexpected 'Rule' log: %s. Expected: %s", entry.Rule, "deny all")
+	}
+	if entry.Allowed {
+		t.Errorf("Unexpected 'Allowed' log: %t. Expected: %t", entry.Allowed, false)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Package analysis implements the detection of black and frozen screens in
+decoded JPEG frames.
+*/
+package analysis
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+)
+
+// Maximum number of luminance samples per image dimension.
+const maxSamples = 256
+
+// Maximum standard deviation of the luminance for a uniform frame.
+const maxUniformDeviation = 4
+
+// Maximum luminance difference of a sample for an unchanged frame.
+const maxSampleDelta = 12
+
+// Condition identifies a detected screen condition.
+type Condition string
+
+const (
+	// Black describes an all black or otherwise uniform screen.
+	Black Condition = "black"
+	// Frozen describes a screen without visual changes.
+	Frozen Condition = "frozen"
+)
+
+// Options configures the Detector.
+// A zero timeout disables the detection of the respective condition.
+type Options struct {
+	Interval      time.Duration
+	BlackTimeout  time.Duration
+	FrozenTimeout time.Duration
+}
+
+// NotifyFunc is called when a condition becomes active or inactive.
+// The since argument provides the time of the first frame with the condition.
+type NotifyFunc func(condition Condition, active bool, since time.Time)
+
+type samples struct {
+	width  int
+	values []uint8
+}
+
+type detector struct {
+	options     Options
+	notify      NotifyFunc
+	busy        int32
+	lock        *sync.Mutex
+	lastTime    time.Time
+	reference   *samples
+	blackSince  time.Time
+	frozenSince time.Time
+	black       bool
+	frozen      bool
+}
+
+// Detector is an interface to detect black and frozen screens.
+// Frames are analyzed asynchronously via the Process method, while the Black
+// and Frozen methods return the currently detected conditions.
+type Detector interface {
+	Process(f *frame.Frame)
+	Reset()
+	Black() bool
+	Frozen() bool
+}
+
+func sample(img image.Image) *samples {
+	bounds := img.Bounds()
+	stepX := (bounds.Dx() + maxSamples - 1) / maxSamples
+	stepY := (bounds.Dy() + maxSamples - 1) / maxSamples
+	if stepX < 1 {
+		stepX = 1
+	}
+	if stepY < 1 {
+		stepY = 1
+	}
+	s := &samples{width: (bounds.Dx() + stepX - 1) / stepX}
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			if isYCbCr {
+				s.values = append(s.values, ycbcr.Y[ycbcr.YOffset(x, y)])
+			} else {
+				gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+				s.values = append(s.values, gray.Y)
+			}
+		}
+	}
+	return s
+}
+
+func (s *samples) uniform() bool {
+	if len(s.values) == 0 {
+		return false
+	}
+	var sum, sumSquares float64
+	for _, v := range s.values {
+		sum += float64(v)
+		sumSquares += float64(v) * float64(v)
+	}
+	n := float64(len(s.values))
+	mean := sum / n
+	return math.Sqrt(math.Max(sumSquares/n-mean*mean, 0)) <= maxUniformDeviation
+}
+
+func (s *samples) equal(other *samples) bool {
+	if other == nil || s.width != other.width ||
+		len(s.values) != len(other.values) {
+		return false
+	}
+	for i, v := range s.values {
+		delta := int(v) - int(other.values[i])
+		if delta > maxSampleDelta || delta < -maxSampleDelta {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *detector) update(
+	condition Condition,
+	state *bool,
+	timeout time.Duration,
+	since time.Time,
+	now time.Time,
+) {
+	active := timeout > 0 && !since.IsZero() && now.Sub(since) >= timeout
+	if active != *state {
+		*state = active
+		d.notify(condition, active, since)
+	}
+}
+
+func (d *detector) analyze(f *frame.Frame) {
+	img, err := jpeg.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		// Ignore frames that cannot be decoded.
+		return
+	}
+	s := sample(img)
+	d.lock.Lock()
+	if !s.uniform() {
+		d.blackSince = time.Time{}
+	} else if d.blackSince.IsZero() {
+		d.blackSince = f.Time
+	}
+	if !s.equal(d.reference) {
+		d.reference = s
+		d.frozenSince = f.Time
+	}
+	d.update(Black, &d.black, d.options.BlackTimeout, d.blackSince, f.Time)
+	d.update(Frozen, &d.frozen, d.options.FrozenTimeout, d.frozenSince, f.Time)
+	d.lock.Unlock()
+}
+
+// Process analyzes the given frame asynchronously.
+// Frames are skipped if they arrive before the configured interval has passed
+// or while a previous frame is still being analyzed.
+func (d *detector) Process(f *frame.Frame) {
+	d.lock.Lock()
+	if !d.lastTime.IsZero() && f.Time.Sub(d.lastTime) < d.options.Interval {
+		d.lock.Unlock()
+		return
+	}
+	d.lastTime = f.Time
+	d.lock.Unlock()
+	if !atomic.CompareAndSwapInt32(&d.busy, 0, 1) {
+		return
+	}
+	go func() {
+		d.analyze(f)
+		atomic.StoreInt32(&d.busy, 0)
+	}()
+}
+
+// Reset clears the detection state, e.g. after the recording stopped.
+func (d *detector) Reset() {
+	d.lock.Lock()
+	// A zero timeout deactivates the conditions.
+	now := time.Now()
+	d.update(Black, &d.black, 0, d.blackSince, now)
+	d.update(Frozen, &d.frozen, 0, d.frozenSince, now)
+	d.lastTime = time.Time{}
+	d.reference = nil
+	d.blackSince = time.Time{}
+	d.frozenSince = time.Time{}
+	d.lock.Unlock()
+}
+
+// Black returns true if a black or uniform screen has been detected.
+func (d *detector) Black() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.black
+}
+
+// Frozen returns true if a frozen screen has been detected.
+func (d *detector) Frozen() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.frozen
+}
+
+// New creates a new Detector, which calls the given NotifyFunc on condition
+// changes.
+func New(options Options, notify NotifyFunc) Detector {
+	return &detector{options: options, notify: notify, lock: &sync.Mutex{}}
+}
@@ -0,0 +1,170 @@
+package analysis
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+)
+
+type notification struct {
+	condition Condition
+	active    bool
+	since     time.Time
+}
+
+func frameHelper(fill func(x, y int) uint8, t time.Time) *frame.Frame {
+	img := image.NewGray(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			img.SetGray(x, y, color.Gray{Y: fill(x, y)})
+		}
+	}
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, nil)
+	return &frame.Frame{Data: buffer.Bytes(), Time: t}
+}
+
+func black(x, y int) uint8 {
+	return 0
+}
+
+func pattern(offset int) func(x, y int) uint8 {
+	return func(x, y int) uint8 {
+		return uint8(((x + offset) / 32 % 2) * 255)
+	}
+}
+
+func detectorHelper(options Options) (*detector, *[]notification) {
+	var notifications []notification
+	d := New(options, func(c Condition, active bool, since time.Time) {
+		notifications = append(notifications, notification{c, active, since})
+	}).(*detector)
+	return d, &notifications
+}
+
+func pendingHelper(d *detector) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.blackSince.IsZero()
+}
+
+func TestNew(t *testing.T) {
+	d := New(Options{}, func(Condition, bool, time.Time) {})
+	if d == nil {
+		t.Error("Unexpected: nil")
+	}
+	_, ok := interface{}(d).(Detector)
+	if !ok {
+		t.Error("Unexpected: not a Detector")
+	}
+}
+
+func TestBlack(t *testing.T) {
+	d, notifications := detectorHelper(Options{BlackTimeout: 2 * time.Second})
+	start := time.Now()
+	d.analyze(frameHelper(black, start))
+	d.analyze(frameHelper(black, start.Add(time.Second)))
+	if d.Black() {
+		t.Error("Unexpected black detection before timeout")
+	}
+	d.analyze(frameHelper(black, start.Add(2*time.Second)))
+	if !d.Black() {
+		t.Error("Expected black detection after timeout")
+	}
+	if d.Frozen() {
+		t.Error("Unexpected frozen detection with disabled timeout")
+	}
+	d.analyze(frameHelper(pattern(0), start.Add(3*time.Second)))
+	if d.Black() {
+		t.Error("Unexpected black detection after change")
+	}
+	expected := []notification{
+		{Black, true, start},
+		{Black, false, time.Time{}},
+	}
+	if len(*notifications) != len(expected) {
+		t.Fatalf(
+			"Unexpected notifications: %v. Expected: %v",
+			*notifications,
+			expected,
+		)
+	}
+	for i, n := range *notifications {
+		if n.condition != expected[i].condition ||
+			n.active != expected[i].active {
+			t.Errorf("Unexpected notification: %v. Expected: %v", n, expected[i])
+		}
+	}
+	if !(*notifications)[0].since.Equal(start) {
+		t.Errorf(
+			"Unexpected since time: %s. Expected: %s",
+			(*notifications)[0].since,
+			start,
+		)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	d, notifications := detectorHelper(Options{FrozenTimeout: 2 * time.Second})
+	start := time.Now()
+	d.analyze(frameHelper(pattern(0), start))
+	d.analyze(frameHelper(pattern(16), start.Add(time.Second)))
+	d.analyze(frameHelper(pattern(16), start.Add(2*time.Second)))
+	if d.Frozen() {
+		t.Error("Unexpected frozen detection before timeout")
+	}
+	d.analyze(frameHelper(pattern(16), start.Add(3*time.Second)))
+	if !d.Frozen() {
+		t.Error("Expected frozen detection after timeout")
+	}
+	if d.Black() {
+		t.Error("Unexpected black detection")
+	}
+	if len(*notifications) != 1 {
+		t.Fatalf("Unexpected notifications: %v", *notifications)
+	}
+	n := (*notifications)[0]
+	if n.condition != Frozen || !n.active ||
+		!n.since.Equal(start.Add(time.Second)) {
+		t.Errorf("Unexpected notification: %v", n)
+	}
+	d.Reset()
+	if d.Frozen() {
+		t.Error("Unexpected frozen detection after reset")
+	}
+	if len(*notifications) != 2 || (*notifications)[1].active {
+		t.Errorf("Unexpected notifications: %v", *notifications)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	d, _ := detectorHelper(Options{
+		Interval:     time.Hour,
+		BlackTimeout: time.Nanosecond,
+	})
+	start := time.Now()
+	d.Process(frameHelper(black, start))
+	d.Process(frameHelper(black, start.Add(time.Second)))
+	for i := 0; i < 100 && pendingHelper(d); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.lock.Lock()
+	since := d.blackSince
+	d.lock.Unlock()
+	if !since.Equal(start) {
+		t.Errorf("Unexpected analyzed frame: %s. Expected: %s", since, start)
+	}
+}
+
+func TestInvalidFrame(t *testing.T) {
+	d, notifications := detectorHelper(Options{BlackTimeout: time.Nanosecond})
+	d.analyze(&frame.Frame{Data: []byte("banana"), Time: time.Now()})
+	if len(*notifications) != 0 {
+		t.Errorf("Unexpected notifications: %v", *notifications)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Package auth implements HTTP basic authentication.
+*/
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// HashPrefix marks passwords given as hex encoded SHA-256 hash.
+const HashPrefix = "sha256:"
+
+// Realm is the protection space announced to unauthenticated clients.
+const Realm = "MJPEG Server"
+
+// Users maps user names to passwords for HTTP basic authentication.
+// Passwords can be given in plain text or as hex encoded SHA-256 hash with
+// "sha256:" prefix.
+type Users map[string]string
+
+func hash(value string) []byte {
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
+
+// Check returns the user name if the request provides valid basic
+// authentication credentials and false otherwise.
+func (u Users) Check(req *http.Request) (string, bool) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	expected, ok := u[username]
+	if !ok {
+		// Compare anyway to not disclose valid user names via timing.
+		expected = ""
+	}
+	var expectedHash []byte
+	if strings.HasPrefix(expected, HashPrefix) {
+		expectedHash, _ = hex.DecodeString(strings.TrimPrefix(expected, HashPrefix))
+	} else {
+		expectedHash = hash(expected)
+	}
+	valid := subtle.ConstantTimeCompare(hash(password), expectedHash) == 1
+	if !ok || !valid {
+		return "", false
+	}
+	return username, true
+}
+
+// Challenge responds with a 401 status and a basic authentication challenge.
+func Challenge(res http.ResponseWriter) {
+	res.Header().Set("WWW-Authenticate", `Basic realm="`+Realm+`"`)
+	res.WriteHeader(http.StatusUnauthorized)
+}
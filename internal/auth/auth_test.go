@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	users := Users{
+		"banana": "apple",
+		// SHA-256 hash of "orange":
+		"cherry": HashPrefix +
+			"1b4c9133da73a711322404314402765ab0d23fd362a167d6f0c65bb215113d94",
+	}
+	tests := []struct {
+		username string
+		password string
+		valid    bool
+	}{
+		{"banana", "apple", true},
+		{"banana", "orange", false},
+		{"cherry", "orange", true},
+		{"cherry", "apple", false},
+		{"apple", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://localhost:9000/", nil)
+		req.SetBasicAuth(test.username, test.password)
+		username, valid := users.Check(req)
+		if valid != test.valid {
+			t.Errorf(
+				"Unexpected result for %s:%s: %t. Expected: %t",
+				test.username,
+				test.password,
+				valid,
+				test.valid,
+			)
+		}
+		if valid && username != test.username {
+			t.Errorf(
+				"Unexpected username: %s. Expected: %s",
+				username,
+				test.username,
+			)
+		}
+	}
+	req := httptest.NewRequest("GET", "http://localhost:9000/", nil)
+	if _, valid := users.Check(req); valid {
+		t.Error("Unexpected valid request without credentials")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Challenge(rec)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusUnauthorized,
+		)
+	}
+	header := rec.Header().Get("WWW-Authenticate")
+	expectedHeader := `Basic realm="MJPEG Server"`
+	if header != expectedHeader {
+		t.Errorf(
+			"Unexpected WWW-Authenticate header: %s. Expected: %s",
+			header,
+			expectedHeader,
+		)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Package config implements loading the JSON configuration file.
+*/
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/blueimp/mjpeg-server/internal/access"
+	"github.com/blueimp/mjpeg-server/internal/auth"
+	"github.com/blueimp/mjpeg-server/internal/crop"
+	"github.com/blueimp/mjpeg-server/internal/mask"
+	"github.com/blueimp/mjpeg-server/internal/mosaic"
+)
+
+// Listener configures a network listener with optional TLS and basic
+// authentication.
+// See package listener for the supported Address formats.
+// Admins authenticate requests to the admin endpoints, which are not served on
+// listeners without Admins.
+type Listener struct {
+	Address    string
+	SocketMode string
+	TLSCert    string
+	TLSKey     string
+	Users      auth.Users
+	Admins     auth.Users
+}
+
+// Stream configures the stream with the given URL path.
+// Access is an ordered list of rules in the format "allow|deny CIDR|IP|all".
+// MaxClients limits the number of connected clients (unlimited if 0).
+// Masks is a list of privacy masks in the format "x,y,width,height[,style]".
+// Crops maps preset names to crop regions in the format "x,y,width,height".
+// MaxKbps is the default bitrate limit per client (unlimited if 0).
+type Stream struct {
+	Path       string
+	Access     []string
+	MaxClients int
+	Masks      []string
+	Crops      map[string]string
+	MaxKbps    int
+}
+
+// Mosaic configures a composite stream with the given URL path, which shows
+// the frames of the given Sources in a labeled grid.
+// Sources are query strings of the stream, e.g. "browser=1" for parameterized
+// commands or "crop=viewport", with an empty string for the plain stream.
+// Columns sets the number of grid columns (square grid if 0), Width the grid
+// width in pixels and FPS the frame rate of the composite stream, up to
+// mosaic.MaxFPS.
+type Mosaic struct {
+	Path    string
+	Sources []string
+	Columns int
+	Width   int
+	FPS     float64
+}
+
+// Webhook configures a URL to receive events as JSON POST requests.
+// Request bodies are signed with the optional Secret and Events limits the
+// delivered events to the given names.
+type Webhook struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+// Config is the content of the configuration file.
+type Config struct {
+	Listeners []Listener
+	Streams   []Stream
+	Mosaics   []Mosaic
+	Webhooks  []Webhook
+}
+
+// Stream returns the configuration for the stream with the given URL path.
+func (c *Config) Stream(path string) *Stream {
+	for i := range c.Streams {
+		if c.Streams[i].Path == path {
+			return &c.Streams[i]
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	for i, l := range c.Listeners {
+		if l.Address == "" {
+			return fmt.Errorf("listener %d: missing Address", i)
+		}
+		if (l.TLSCert == "") != (l.TLSKey == "") {
+			return fmt.Errorf("listener %d: TLSCert requires TLSKey", i)
+		}
+	}
+	paths := make(map[string]bool)
+	for i, s := range c.Streams {
+		if s.Path == "" {
+			return fmt.Errorf("stream %d: missing Path", i)
+		}
+		if paths[s.Path] {
+			return fmt.Errorf("stream %d: duplicate Path %s", i, s.Path)
+		}
+		paths[s.Path] = true
+		if s.MaxClients < 0 {
+			return fmt.Errorf("stream %s: negative MaxClients", s.Path)
+		}
+		if s.MaxKbps < 0 {
+			return fmt.Errorf("stream %s: negative MaxKbps", s.Path)
+		}
+		if _, err := access.Parse(s.Access); err != nil {
+			return fmt.Errorf("stream %s: %s", s.Path, err)
+		}
+		if _, err := mask.Parse(s.Masks); err != nil {
+			return fmt.Errorf("stream %s: %s", s.Path, err)
+		}
+		for name, spec := range s.Crops {
+			if name == "" || strings.Contains(name, ",") {
+				return fmt.Errorf("stream %s: invalid crop name %q", s.Path, name)
+			}
+			if _, err := crop.Parse(spec); err != nil {
+				return fmt.Errorf("stream %s: %s", s.Path, err)
+			}
+		}
+	}
+	for i, m := range c.Mosaics {
+		if m.Path == "" {
+			return fmt.Errorf("mosaic %d: missing Path", i)
+		}
+		if paths[m.Path] {
+			return fmt.Errorf("mosaic %d: duplicate Path %s", i, m.Path)
+		}
+		paths[m.Path] = true
+		if len(m.Sources) == 0 {
+			return fmt.Errorf("mosaic %s: missing Sources", m.Path)
+		}
+		for _, source := range m.Sources {
+			if _, err := url.ParseQuery(source); err != nil {
+				return fmt.Errorf("mosaic %s: invalid source %q", m.Path, source)
+			}
+		}
+		if m.Columns < 0 || m.Width < 0 || m.FPS < 0 {
+			return fmt.Errorf("mosaic %s: negative Columns, Width or FPS", m.Path)
+		}
+		if m.FPS > mosaic.MaxFPS {
+			return fmt.Errorf("mosaic %s: FPS exceeds %d", m.Path, mosaic.MaxFPS)
+		}
+	}
+	for i, w := range c.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook %d: invalid URL %s", i, w.URL)
+		}
+	}
+	return nil
+}
+
+// Load reads and validates the configuration file at the given path.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	config := &Config{}
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	err = config.validate()
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return config, nil
+}
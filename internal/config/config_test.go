@@ -0,0 +1,149 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigHelper(content string) (path string, cleanup func()) {
+	tmpDir, _ := ioutil.TempDir("", "config")
+	path = filepath.Join(tmpDir, "config.json")
+	ioutil.WriteFile(path, []byte(content), 0600)
+	return path, func() { os.RemoveAll(tmpDir) }
+}
+
+func TestLoad(t *testing.T) {
+	path, cleanup := writeConfigHelper(`{
+		"Listeners": [
+			{"Address": "127.0.0.1:9000"},
+			{
+				"Address": "unix:/run/mjpeg.sock",
+				"SocketMode": "0660",
+				"Admins": {"orange": "sha256:00"}
+			},
+			{
+				"Address": ":9443",
+				"TLSCert": "cert.pem",
+				"TLSKey": "key.pem",
+				"Users": {"banana": "apple"}
+			}
+		],
+		"Streams": [
+			{
+				"Path": "/",
+				"Access": ["allow 10.0.0.0/8", "deny all"],
+				"MaxClients": 10,
+				"Masks": ["0,0,100%,10%,pixelate"],
+				"Crops": {"viewport": "0,80,1920,1000"},
+				"MaxKbps": 2048
+			}
+		],
+		"Mosaics": [
+			{
+				"Path": "/grid",
+				"Sources": ["browser=1", "browser=2"],
+				"Columns": 2,
+				"FPS": 1
+			}
+		],
+		"Webhooks": [
+			{
+				"URL": "https://example.org/hook",
+				"Secret": "banana",
+				"Events": ["recording-crash"]
+			}
+		]
+	}`)
+	defer cleanup()
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(config.Listeners) != 3 {
+		t.Fatalf(
+			"Unexpected listeners: %d. Expected: %d",
+			len(config.Listeners),
+			3,
+		)
+	}
+	if config.Listeners[1].SocketMode != "0660" {
+		t.Errorf(
+			"Unexpected socket mode: %s. Expected: %s",
+			config.Listeners[1].SocketMode,
+			"0660",
+		)
+	}
+	if config.Listeners[1].Admins["orange"] != "sha256:00" {
+		t.Errorf("Unexpected admins: %v", config.Listeners[1].Admins)
+	}
+	if config.Listeners[2].Users["banana"] != "apple" {
+		t.Errorf("Unexpected users: %v", config.Listeners[2].Users)
+	}
+	stream := config.Stream("/")
+	if stream == nil {
+		t.Fatal("Unexpected: nil")
+	}
+	if len(stream.Access) != 2 {
+		t.Errorf("Unexpected access rules: %v", stream.Access)
+	}
+	if stream.MaxClients != 10 {
+		t.Errorf(
+			"Unexpected max clients: %d. Expected: %d",
+			stream.MaxClients,
+			10,
+		)
+	}
+	if len(stream.Masks) != 1 {
+		t.Errorf("Unexpected masks: %v", stream.Masks)
+	}
+	if stream.Crops["viewport"] != "0,80,1920,1000" {
+		t.Errorf("Unexpected crops: %v", stream.Crops)
+	}
+	if stream.MaxKbps != 2048 {
+		t.Errorf("Unexpected max kbps: %d. Expected: %d", stream.MaxKbps, 2048)
+	}
+	if len(config.Mosaics) != 1 || len(config.Mosaics[0].Sources) != 2 {
+		t.Errorf("Unexpected mosaics: %v", config.Mosaics)
+	}
+	if len(config.Webhooks) != 1 || config.Webhooks[0].Secret != "banana" {
+		t.Errorf("Unexpected webhooks: %v", config.Webhooks)
+	}
+	if config.Stream("/banana") != nil {
+		t.Error("Unexpected stream for unknown path")
+	}
+}
+
+func TestLoadWithInvalidConfig(t *testing.T) {
+	for _, content := range []string{
+		`{"Listeners": [{"Address": ":9000", "Banana": true}]}`,
+		`{"Listeners": [{"SocketMode": "0660"}]}`,
+		`{"Listeners": [{"Address": ":9443", "TLSCert": "cert.pem"}]}`,
+		`{"Listeners": [`,
+		`{"Streams": [{"Access": ["deny all"]}]}`,
+		`{"Streams": [{"Path": "/"}, {"Path": "/"}]}`,
+		`{"Streams": [{"Path": "/", "Access": ["deny banana"]}]}`,
+		`{"Streams": [{"Path": "/", "Masks": ["0,0,banana"]}]}`,
+		`{"Streams": [{"Path": "/", "Crops": {"a": "0,0,banana"}}]}`,
+		`{"Streams": [{"Path": "/", "Crops": {"": "0,0,10,10"}}]}`,
+		`{"Streams": [{"Path": "/", "MaxKbps": -1}]}`,
+		`{"Mosaics": [{"Sources": ["a=1"]}]}`,
+		`{"Mosaics": [{"Path": "/grid"}]}`,
+		`{"Mosaics": [{"Path": "/grid", "Sources": ["a=%zz"]}]}`,
+		`{"Mosaics": [{"Path": "/grid", "Sources": [""], "FPS": -1}]}`,
+		`{"Mosaics": [{"Path": "/grid", "Sources": [""], "FPS": 1e12}]}`,
+		`{"Streams": [{"Path": "/"}], "Mosaics": [{"Path": "/", "Sources": [""]}]}`,
+	} {
+		path, cleanup := writeConfigHelper(content)
+		_, err := Load(path)
+		cleanup()
+		if err == nil {
+			t.Errorf("Unexpected nil error for config: %s", content)
+		}
+	}
+	_, err := Load("invalid.json")
+	if err == nil {
+		t.Error("Unexpected nil error for missing config file")
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Package crop implements cutting regions of interest from JPEG frames.
+*/
+package crop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strconv"
+	"strings"
+)
+
+// JPEG quality of cropped frames.
+const quality = 90
+
+// ErrOutside is returned if the crop region does not overlap the frame.
+var ErrOutside = errors.New("crop region outside of frame")
+
+// subImager is implemented by the image types returned by jpeg.Decode.
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// Parse returns the region for a string in the format "x,y,width,height",
+// given in pixels.
+func Parse(spec string) (image.Rectangle, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid crop: %s", spec)
+	}
+	var values [4]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value < 0 {
+			return image.Rectangle{}, fmt.Errorf("invalid crop: %s", spec)
+		}
+		values[i] = value
+	}
+	if values[2] == 0 || values[3] == 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid crop: %s", spec)
+	}
+	return image.Rect(
+		values[0],
+		values[1],
+		values[0]+values[2],
+		values[1]+values[3],
+	), nil
+}
+
+// Key returns the canonical string for the given region, which is accepted by
+// Parse.
+func Key(rect image.Rectangle) string {
+	return fmt.Sprintf(
+		"%d,%d,%d,%d",
+		rect.Min.X,
+		rect.Min.Y,
+		rect.Dx(),
+		rect.Dy(),
+	)
+}
+
+// Apply returns the given JPEG data cut to the given region.
+// Regions exceeding the frame are limited to the frame bounds, while the data
+// is returned unchanged if the region covers the complete frame.
+func Apply(data []byte, rect image.Rectangle) ([]byte, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	rect = rect.Intersect(bounds)
+	if rect.Empty() {
+		return nil, ErrOutside
+	}
+	if rect == bounds {
+		return data, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	sub, ok := img.(subImager)
+	if !ok {
+		return nil, fmt.Errorf("unsupported image type: %T", img)
+	}
+	var buffer bytes.Buffer
+	options := &jpeg.Options{Quality: quality}
+	err = jpeg.Encode(&buffer, sub.SubImage(rect), options)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
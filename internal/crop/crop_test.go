@@ -0,0 +1,73 @@
+package crop
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func jpegHelper(width, height int) []byte {
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, width, height)), nil)
+	return buffer.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	rect, err := Parse("10, 20, 300,400")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := image.Rect(10, 20, 310, 420)
+	if rect != expected {
+		t.Errorf("Unexpected rect: %v. Expected: %v", rect, expected)
+	}
+	if key := Key(rect); key != "10,20,300,400" {
+		t.Errorf("Unexpected key: %s. Expected: %s", key, "10,20,300,400")
+	}
+	for _, spec := range []string{"", "0,0,10", "0,0,0,10", "-1,0,10,10", "a"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Unexpected nil error for spec: %s", spec)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	data := jpegHelper(200, 100)
+	for _, test := range []struct {
+		rect   image.Rectangle
+		width  int
+		height int
+	}{
+		{image.Rect(10, 20, 110, 70), 100, 50},
+		{image.Rect(150, 50, 400, 400), 50, 50},
+	} {
+		cropped, err := Apply(data, test.rect)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(cropped))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if config.Width != test.width || config.Height != test.height {
+			t.Errorf(
+				"Unexpected size: %dx%d. Expected: %dx%d",
+				config.Width,
+				config.Height,
+				test.width,
+				test.height,
+			)
+		}
+	}
+	unchanged, err := Apply(data, image.Rect(0, 0, 1000, 1000))
+	if err != nil || !bytes.Equal(unchanged, data) {
+		t.Error("Unexpected modification for region covering the frame")
+	}
+	if _, err := Apply(data, image.Rect(300, 0, 400, 10)); err != ErrOutside {
+		t.Errorf("Unexpected error: %v. Expected: %v", err, ErrOutside)
+	}
+	if _, err := Apply([]byte("banana"), image.Rect(0, 0, 1, 1)); err == nil {
+		t.Error("Unexpected nil error for invalid data")
+	}
+}
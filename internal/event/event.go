@@ -0,0 +1,53 @@
+/*
+Package event implements structured stream events, which are printed as JSON to
+STDOUT and passed on to subscribed handlers.
+*/
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event describes a state change of a stream.
+type Event struct {
+	Event  string
+	Stream string
+	Time   time.Time
+	Data   map[string]interface{} `json:",omitempty"`
+}
+
+// HandleFunc processes an emitted Event.
+type HandleFunc func(e Event)
+
+var (
+	handlers []HandleFunc
+	lock     = &sync.RWMutex{}
+)
+
+// Subscribe registers the given HandleFunc to be called for each Event.
+func Subscribe(handle HandleFunc) {
+	lock.Lock()
+	handlers = append(handlers, handle)
+	lock.Unlock()
+}
+
+// Emit prints the Event with the given name, stream and data as JSON to STDOUT
+// and calls the subscribed handlers.
+func Emit(name string, stream string, data map[string]interface{}) {
+	e := Event{
+		Event:  name,
+		Stream: stream,
+		Time:   time.Now().UTC(),
+		Data:   data,
+	}
+	b, _ := json.Marshal(e)
+	fmt.Println(string(b))
+	lock.RLock()
+	for _, handle := range handlers {
+		handle(e)
+	}
+	lock.RUnlock()
+}
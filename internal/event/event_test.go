@@ -0,0 +1,76 @@
+package event
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func outputHelper(fn func()) (stdout []byte, stderr []byte) {
+	outReader, outWriter, _ := os.Pipe()
+	errReader, errWriter, _ := os.Pipe()
+	originalOut := os.Stdout
+	originalErr := os.Stderr
+	os.Stdout = outWriter
+	os.Stderr = errWriter
+	fn()
+	outWriter.Close()
+	errWriter.Close()
+	stdout, _ = ioutil.ReadAll(outReader)
+	stderr, _ = ioutil.ReadAll(errReader)
+	os.Stdout = originalOut
+	os.Stderr = originalErr
+	return
+}
+
+func TestEmit(t *testing.T) {
+	var received []Event
+	Subscribe(func(e Event) {
+		received = append(received, e)
+	})
+	timeBefore := time.Now()
+	stdout, stderr := outputHelper(func() {
+		Emit("black-start", "/", map[string]interface{}{"Banana": 1})
+	})
+	timeAfter := time.Now()
+	if string(stderr) != "" {
+		t.Errorf("Unexpected stderr: %s", stderr)
+	}
+	var entry Event
+	json.Unmarshal(stdout, &entry)
+	if entry.Event != "black-start" {
+		t.Errorf(
+			"Unexpected 'Event' log: %s. Expected: %s",
+			entry.Event,
+			"black-start",
+		)
+	}
+	if entry.Stream != "/" {
+		t.Errorf("Unexpected 'Stream' log: %s. Expected: %s", entry.Stream, "/")
+	}
+	if entry.Time.Before(timeBefore) {
+		t.Errorf("Unexpected 'Time' log: %s", entry.Time)
+	}
+	if entry.Time.After(timeAfter) {
+		t.Errorf("Unexpected 'Time' log: %s", entry.Time)
+	}
+	if entry.Data["Banana"] != 1.0 {
+		t.Errorf(
+			"Unexpected 'Data' log: %v. Expected: %v",
+			entry.Data["Banana"],
+			1,
+		)
+	}
+	if len(received) != 1 {
+		t.Fatalf("Unexpected received events: %d. Expected: %d", len(received), 1)
+	}
+	if received[0].Event != "black-start" {
+		t.Errorf(
+			"Unexpected received event: %s. Expected: %s",
+			received[0].Event,
+			"black-start",
+		)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+Package export implements the conversion of JPEG frames into animated GIFs.
+*/
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+	"github.com/blueimp/mjpeg-server/internal/resize"
+)
+
+// Default export settings, used for zero Options values.
+const (
+	DefaultFPS    = 5
+	DefaultColors = 256
+)
+
+// Maximum number of pixels per frame sampled for the palette.
+const maxSamples = 16384
+
+// Unit of the GIF frame delays.
+const delayUnit = 10 * time.Millisecond
+
+// Minimum frame delay in 100ths of a second, as lower values are not
+// displayed as given by most browsers.
+const minDelay = 2
+
+// MaxFPS is the highest frame rate which can be displayed with the minimum
+// frame delay.
+const MaxFPS = 100 / minDelay
+
+// Errors returned by GIF.
+var (
+	ErrNoFrames = errors.New("no frames to export")
+	ErrTooLarge = errors.New(
+		"export too large, reduce the time range, fps or width",
+	)
+)
+
+// Options configures the GIF export.
+// FPS limits the frame rate by skipping frames, while frames wider than
+// MaxWidth are scaled down (unlimited if 0). Colors is the palette size, which
+// is limited to 256.
+// Exports with more than MaxFrames frames or MaxPixels pixels of all frames
+// combined are rejected (unlimited if 0).
+type Options struct {
+	FPS       float64
+	MaxWidth  int
+	Colors    int
+	MaxFrames int
+	MaxPixels int
+}
+
+// ParseTime returns the time for the given RFC 3339 timestamp or for the given
+// duration relative to the reference time, e.g. "-10s".
+func ParseTime(value string, reference time.Time) (time.Time, error) {
+	if timestamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return timestamp, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(
+			"invalid time, must be an RFC 3339 timestamp or duration: %s",
+			value,
+		)
+	}
+	return reference.Add(duration), nil
+}
+
+// decimate returns the frames limited to the given frame interval.
+func decimate(frames []*frame.Frame, interval time.Duration) []*frame.Frame {
+	selected := []*frame.Frame{frames[0]}
+	for _, f := range frames[1:] {
+		if f.Time.Sub(selected[len(selected)-1].Time) >= interval {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
+
+// delays returns the display durations of the given frames in 100ths of a
+// second, using the given frame interval for the last frame.
+func delays(frames []*frame.Frame, interval time.Duration) []int {
+	result := make([]int, len(frames))
+	for i := range frames {
+		duration := interval
+		if i+1 < len(frames) {
+			duration = frames[i+1].Time.Sub(frames[i].Time)
+		}
+		result[i] = int(duration.Round(delayUnit) / delayUnit)
+		if result[i] < minDelay {
+			result[i] = minDelay
+		}
+	}
+	return result
+}
+
+// scaledSize returns the size of an image with the given size, scaled down to
+// the given maximum width.
+func scaledSize(width int, height int, maxWidth int) (int, int) {
+	if maxWidth <= 0 || width <= maxWidth {
+		return width, height
+	}
+	scaledHeight := height * maxWidth / width
+	if scaledHeight < 1 {
+		scaledHeight = 1
+	}
+	return maxWidth, scaledHeight
+}
+
+// scale returns the image as RGBA, scaled down to the given maximum width.
+func scale(img image.Image, maxWidth int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := scaledSize(bounds.Dx(), bounds.Dy(), maxWidth)
+	return resize.Box(img, width, height)
+}
+
+// box is a set of sampled colors for the median cut quantization.
+type box [][3]uint8
+
+// channel returns the color channel with the largest value range and the
+// size of the range.
+func (b box) channel() (channel int, size int) {
+	for c := 0; c < 3; c++ {
+		min, max := 255, 0
+		for _, sample := range b {
+			value := int(sample[c])
+			if value < min {
+				min = value
+			}
+			if value > max {
+				max = value
+			}
+		}
+		if max-min > size {
+			channel, size = c, max-min
+		}
+	}
+	return
+}
+
+// average returns the mean color of the box.
+func (b box) average() color.Color {
+	var sum [3]int
+	for _, sample := range b {
+		for c := range sum {
+			sum[c] += int(sample[c])
+		}
+	}
+	n := len(b)
+	return color.RGBA{
+		uint8(sum[0] / n),
+		uint8(sum[1] / n),
+		uint8(sum[2] / n),
+		255,
+	}
+}
+
+// quantize returns a palette with the given number of colors for the given
+// images, using the median cut algorithm on sampled pixels.
+func quantize(images []*image.RGBA, colors int) color.Palette {
+	var samples box
+	for _, img := range images {
+		pixels := len(img.Pix) / 4
+		step := int(math.Ceil(float64(pixels) / maxSamples))
+		for i := 0; i < pixels; i += step {
+			samples = append(
+				samples,
+				[3]uint8{img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2]},
+			)
+		}
+	}
+	boxes := []box{samples}
+	for len(boxes) < colors {
+		// Split the box with the largest color range at its median.
+		index, channel, size := 0, 0, 0
+		for i, b := range boxes {
+			if c, s := b.channel(); s > size {
+				index, channel, size = i, c, s
+			}
+		}
+		if size == 0 {
+			break
+		}
+		b := boxes[index]
+		sort.Slice(b, func(i, j int) bool {
+			return b[i][channel] < b[j][channel]
+		})
+		median := len(b) / 2
+		boxes[index] = b[:median]
+		boxes = append(boxes, b[median:])
+	}
+	palette := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		palette[i] = b.average()
+	}
+	return palette
+}
+
+// checkSize returns ErrTooLarge if the given frames exceed the maximum number
+// of frames or pixels, using the sizes from the JPEG headers.
+func checkSize(frames []*frame.Frame, options Options) error {
+	if options.MaxFrames > 0 && len(frames) > options.MaxFrames {
+		return ErrTooLarge
+	}
+	if options.MaxPixels <= 0 {
+		return nil
+	}
+	pixels := 0
+	for _, f := range frames {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(f.Data))
+		if err != nil {
+			continue
+		}
+		width, height := scaledSize(config.Width, config.Height, options.MaxWidth)
+		pixels += width * height
+		if pixels > options.MaxPixels {
+			return ErrTooLarge
+		}
+	}
+	return nil
+}
+
+// GIF writes the given JPEG frames as animated GIF to the given Writer.
+// Frames which cannot be decoded are skipped.
+// It returns ErrTooLarge before decoding the frames if the export exceeds the
+// maximum number of frames or pixels.
+func GIF(w io.Writer, frames []*frame.Frame, options Options) error {
+	if options.FPS <= 0 {
+		options.FPS = DefaultFPS
+	}
+	if options.Colors <= 0 || options.Colors > 256 {
+		options.Colors = DefaultColors
+	}
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	interval := time.Duration(float64(time.Second) / options.FPS)
+	selected := decimate(frames, interval)
+	if err := checkSize(selected, options); err != nil {
+		return err
+	}
+	decoded := make([]*frame.Frame, 0, len(selected))
+	images := make([]*image.RGBA, 0, len(selected))
+	for _, f := range selected {
+		img, err := jpeg.Decode(bytes.NewReader(f.Data))
+		if err != nil {
+			continue
+		}
+		decoded = append(decoded, f)
+		images = append(images, scale(img, options.MaxWidth))
+	}
+	if len(images) == 0 {
+		return ErrNoFrames
+	}
+	palette := quantize(images, options.Colors)
+	animation := &gif.GIF{Delay: delays(decoded, interval)}
+	for _, img := range images {
+		paletted := image.NewPaletted(img.Bounds(), palette)
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, image.Point{})
+		animation.Image = append(animation.Image, paletted)
+	}
+	return gif.EncodeAll(w, animation)
+}
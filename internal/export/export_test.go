@@ -0,0 +1,142 @@
+package export
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+)
+
+var start = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// framesHelper returns frames with alternating colors at the given rate.
+func framesHelper(num int, fps int, width int, height int) []*frame.Frame {
+	frames := make([]*frame.Frame, num)
+	for i := range frames {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		c := color.RGBA{255, 0, 0, 255}
+		if i%2 == 1 {
+			c = color.RGBA{0, 0, 255, 255}
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		var buffer bytes.Buffer
+		jpeg.Encode(&buffer, img, nil)
+		frames[i] = &frame.Frame{
+			Data: buffer.Bytes(),
+			Time: start.Add(time.Duration(i) * time.Second / time.Duration(fps)),
+		}
+	}
+	return frames
+}
+
+func TestGIF(t *testing.T) {
+	frames := framesHelper(20, 20, 200, 100)
+	frames = append(frames, &frame.Frame{
+		Data: []byte("banana"),
+		Time: start.Add(2 * time.Second),
+	})
+	var buffer bytes.Buffer
+	err := GIF(&buffer, frames, Options{FPS: 4, MaxWidth: 50, Colors: 16})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	animation, err := gif.DecodeAll(&buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// One second of frames at 4 FPS, while the invalid frame is skipped.
+	if len(animation.Image) != 4 {
+		t.Fatalf(
+			"Unexpected number of frames: %d. Expected: %d",
+			len(animation.Image),
+			4,
+		)
+	}
+	for i, img := range animation.Image {
+		bounds := img.Bounds()
+		if bounds.Dx() != 50 || bounds.Dy() != 25 {
+			t.Errorf("Unexpected frame size: %v", bounds)
+		}
+		if len(img.Palette) > 16 {
+			t.Errorf("Unexpected palette size: %d", len(img.Palette))
+		}
+		if animation.Delay[i] != 25 {
+			t.Errorf(
+				"Unexpected delay: %d. Expected: %d",
+				animation.Delay[i],
+				25,
+			)
+		}
+	}
+	if err := GIF(&buffer, nil, Options{}); err != ErrNoFrames {
+		t.Errorf("Unexpected error: %v. Expected: %v", err, ErrNoFrames)
+	}
+}
+
+func TestGIFWithMaxSize(t *testing.T) {
+	frames := framesHelper(20, 20, 200, 100)
+	var buffer bytes.Buffer
+	for _, options := range []Options{
+		{FPS: 20, MaxFrames: 19},
+		{FPS: 20, MaxPixels: 20*200*100 - 1},
+		{FPS: 20, MaxWidth: 100, MaxPixels: 20*100*50 - 1},
+	} {
+		if err := GIF(&buffer, frames, options); err != ErrTooLarge {
+			t.Errorf(
+				"Unexpected error for %+v: %v. Expected: %v",
+				options,
+				err,
+				ErrTooLarge,
+			)
+		}
+	}
+	for _, options := range []Options{
+		{FPS: 20, MaxFrames: 20, MaxPixels: 20 * 200 * 100},
+		{FPS: 20, MaxWidth: 100, MaxPixels: 20 * 100 * 50},
+		{FPS: 10, MaxFrames: 10},
+	} {
+		if err := GIF(&buffer, frames, options); err != nil {
+			t.Errorf("Unexpected error for %+v: %s", options, err)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{0, 0, 255, 255})
+	palette := quantize([]*image.RGBA{img}, 256)
+	if len(palette) != 2 {
+		t.Errorf("Unexpected palette size: %d. Expected: %d", len(palette), 2)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for value, expected := range map[string]time.Time{
+		"2020-05-01T12:00:05Z": start.Add(5 * time.Second),
+		"-10s":                 start.Add(-10 * time.Second),
+		"1m":                   start.Add(time.Minute),
+	} {
+		parsed, err := ParseTime(value, start)
+		if err != nil || !parsed.Equal(expected) {
+			t.Errorf(
+				"Unexpected time for %s: %s. Expected: %s",
+				value,
+				parsed,
+				expected,
+			)
+		}
+	}
+	if _, err := ParseTime("banana", start); err == nil {
+		t.Error("Unexpected nil error")
+	}
+}
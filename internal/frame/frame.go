@@ -0,0 +1,274 @@
+/*
+Package frame implements a parser for multipart JPEG streams, which splits the
+stream into individual frames.
+The multipart boundary is detected from the first line of the stream.
+*/
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Maximum number of buffered bytes before the parser discards its input.
+const maxBufferSize = 64 << 20
+
+const (
+	stateBoundary = iota
+	stateHeader
+	stateBody
+)
+
+// Frame is a single part of a multipart JPEG stream.
+// Time is the receive time, while the Sequence number is assigned by the
+// consumer of the frames.
+type Frame struct {
+	Header   textproto.MIMEHeader
+	Data     []byte
+	Time     time.Time
+	Sequence uint64
+}
+
+// HandleFunc processes a parsed Frame.
+type HandleFunc func(f *Frame)
+
+// Headers set by Encode, which replace the headers provided by the command.
+var encodedHeaders = map[string]bool{
+	"Content-Type":   true,
+	"Content-Length": true,
+	"X-Timestamp":    true,
+	"X-Sequence":     true,
+}
+
+type parser struct {
+	boundary  string
+	delimiter []byte
+	handle    HandleFunc
+	buffer    []byte
+	state     int
+	header    textproto.MIMEHeader
+	length    int
+	offset    int
+}
+
+// Write implements io.Writer and calls the HandleFunc for each complete frame.
+// It never returns an error, as malformed input is skipped.
+func (p *parser) Write(b []byte) (int, error) {
+	p.buffer = append(p.buffer, b...)
+	for p.next() {
+	}
+	if len(p.buffer) > maxBufferSize {
+		// Discard the input if no frame could be found.
+		p.buffer = nil
+		p.state = stateBoundary
+		p.offset = 0
+	}
+	return len(b), nil
+}
+
+// next processes the buffered input for the current state.
+// It returns false if more input is required.
+func (p *parser) next() bool {
+	switch p.state {
+	case stateBoundary:
+		if p.delimiter == nil {
+			return p.detectBoundary()
+		}
+		return p.nextBoundary()
+	case stateHeader:
+		return p.nextHeader()
+	default:
+		return p.nextBody()
+	}
+}
+
+// detectBoundary sets the delimiter from the first line starting with "--".
+// Preceding lines are skipped as multipart preamble.
+func (p *parser) detectBoundary() bool {
+	end := bytes.IndexByte(p.buffer, '\n')
+	if end == -1 {
+		return false
+	}
+	line := bytes.TrimRight(p.buffer[:end], " \t\r")
+	if !bytes.HasPrefix(line, []byte("--")) || len(line) == 2 {
+		p.buffer = p.buffer[end+1:]
+		return true
+	}
+	boundary := string(bytes.TrimSuffix(line[2:], []byte("--")))
+	if p.boundary != "" && p.boundary != boundary {
+		log.Printf(
+			"Detected multipart boundary %q differs from configured boundary %q",
+			boundary,
+			p.boundary,
+		)
+	}
+	p.delimiter = []byte("--" + boundary)
+	return true
+}
+
+func (p *parser) nextBoundary() bool {
+	index := bytes.Index(p.buffer, p.delimiter)
+	if index == -1 {
+		// Keep enough bytes to match a delimiter split across writes.
+		if len(p.buffer) > len(p.delimiter) {
+			p.buffer = p.buffer[len(p.buffer)-len(p.delimiter):]
+		}
+		return false
+	}
+	if index > 0 && p.buffer[index-1] != '\n' {
+		// The delimiter must be at the beginning of a line.
+		p.buffer = p.buffer[index+1:]
+		return true
+	}
+	end := bytes.IndexByte(p.buffer[index:], '\n')
+	if end == -1 {
+		p.buffer = p.buffer[index:]
+		return false
+	}
+	line := p.buffer[index+len(p.delimiter) : index+end]
+	p.buffer = p.buffer[index+end+1:]
+	if bytes.HasPrefix(line, []byte("--")) {
+		// Close delimiter, e.g. from a stopped command. Wait for the next part.
+		return true
+	}
+	if len(bytes.TrimRight(line, " \t\r")) != 0 {
+		// Different boundary sharing the same prefix.
+		return true
+	}
+	p.state = stateHeader
+	return true
+}
+
+func (p *parser) nextHeader() bool {
+	end := 0
+	for {
+		index := bytes.IndexByte(p.buffer[end:], '\n')
+		if index == -1 {
+			return false
+		}
+		line := bytes.TrimRight(p.buffer[end:end+index], "\r")
+		end += index + 1
+		if len(line) == 0 {
+			break
+		}
+	}
+	reader := bufio.NewReader(bytes.NewReader(p.buffer[:end]))
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		header = make(textproto.MIMEHeader)
+	}
+	p.header = header
+	p.length = -1
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil &&
+		length >= 0 {
+		p.length = length
+	}
+	p.buffer = p.buffer[end:]
+	p.state = stateBody
+	p.offset = 0
+	return true
+}
+
+func (p *parser) nextBody() bool {
+	var data []byte
+	if p.length >= 0 {
+		if len(p.buffer) < p.length {
+			return false
+		}
+		data = p.buffer[:p.length]
+		p.buffer = p.buffer[p.length:]
+	} else {
+		var index int
+		for {
+			i := bytes.Index(p.buffer[p.offset:], p.delimiter)
+			if i == -1 {
+				if len(p.buffer) > len(p.delimiter) {
+					p.offset = len(p.buffer) - len(p.delimiter)
+				}
+				return false
+			}
+			i += p.offset
+			end := i + len(p.delimiter)
+			if end == len(p.buffer) {
+				// Wait for the character following the delimiter.
+				p.offset = i
+				return false
+			}
+			if i > 0 && p.buffer[i-1] == '\n' &&
+				bytes.IndexByte([]byte("\r\n \t-"), p.buffer[end]) != -1 {
+				index = i
+				break
+			}
+			p.offset = i + 1
+		}
+		data = bytes.TrimSuffix(p.buffer[:index-1], []byte("\r"))
+		p.buffer = p.buffer[index:]
+	}
+	frame := &Frame{
+		Header: p.header,
+		Data:   append([]byte(nil), data...),
+		Time:   time.Now(),
+	}
+	p.state = stateBoundary
+	p.offset = 0
+	p.handle(frame)
+	return true
+}
+
+// Encode returns the Frame as multipart body part with the given boundary.
+// The part header includes the Content-Length of the frame data, the receive
+// time as X-Timestamp in RFC 3339 format and the X-Sequence number, which is
+// left out for frames without Sequence, e.g. placeholder frames.
+func (f *Frame) Encode(boundary string) []byte {
+	var buffer bytes.Buffer
+	buffer.Grow(len(f.Data) + len(boundary) + 256)
+	buffer.WriteString("--" + boundary + "\r\n")
+	contentType := f.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+	buffer.WriteString("Content-Type: " + contentType + "\r\n")
+	keys := make([]string, 0, len(f.Header))
+	for key := range f.Header {
+		if !encodedHeaders[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range f.Header[key] {
+			buffer.WriteString(key + ": " + value + "\r\n")
+		}
+	}
+	buffer.WriteString("Content-Length: " + strconv.Itoa(len(f.Data)) + "\r\n")
+	buffer.WriteString(
+		"X-Timestamp: " + f.Time.UTC().Format(time.RFC3339Nano) + "\r\n",
+	)
+	if f.Sequence > 0 {
+		buffer.WriteString(
+			"X-Sequence: " + strconv.FormatUint(f.Sequence, 10) + "\r\n",
+		)
+	}
+	buffer.WriteString("\r\n")
+	buffer.Write(f.Data)
+	buffer.WriteString("\r\n")
+	return buffer.Bytes()
+}
+
+// NewParser creates a Writer which parses a multipart stream and calls the
+// given HandleFunc for each frame.
+// The boundary is detected from the stream. If the optional boundary argument
+// differs from the detected one, a warning is logged.
+func NewParser(boundary string, handle HandleFunc) io.Writer {
+	return &parser{
+		boundary: boundary,
+		handle:   handle,
+		state:    stateBoundary,
+	}
+}
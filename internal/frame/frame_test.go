@@ -0,0 +1,275 @@
+package frame
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseHelper(input []byte, chunkSize int) (frames []*Frame) {
+	parser := NewParser("ffmpeg", func(f *Frame) {
+		frames = append(frames, f)
+	})
+	for len(input) > chunkSize {
+		parser.Write(input[:chunkSize])
+		input = input[chunkSize:]
+	}
+	parser.Write(input)
+	return
+}
+
+func TestNewParser(t *testing.T) {
+	parser := NewParser("ffmpeg", func(f *Frame) {})
+	if parser == nil {
+		t.Error("Unexpected: nil")
+	}
+	_, ok := interface{}(parser).(io.Writer)
+	if !ok {
+		t.Error("Unexpected: not an io.Writer")
+	}
+}
+
+func TestParse(t *testing.T) {
+	imageData, _ := ioutil.ReadFile("../../gopher.jpg")
+	input := bytes.Join(
+		[][]byte{
+			[]byte("--ffmpeg"),
+			[]byte("Content-Type: image/jpeg"),
+			[]byte(""),
+			imageData,
+			[]byte("--ffmpeg"),
+			[]byte("Content-Type: image/jpeg"),
+			[]byte(""),
+			[]byte("banana"),
+			[]byte("--ffmpeg--"),
+			[]byte(""),
+		},
+		[]byte("\r\n"),
+	)
+	for _, chunkSize := range []int{1, 7, 4096, len(input)} {
+		frames := parseHelper(input, chunkSize)
+		if len(frames) != 2 {
+			t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+		}
+		if !bytes.Equal(frames[0].Data, imageData) {
+			t.Errorf("Unexpected frame data with chunk size %d", chunkSize)
+		}
+		if string(frames[1].Data) != "banana" {
+			t.Errorf(
+				"Unexpected frame data: %s. Expected: %s",
+				frames[1].Data,
+				"banana",
+			)
+		}
+		contentType := frames[0].Header.Get("Content-Type")
+		if contentType != "image/jpeg" {
+			t.Errorf(
+				"Unexpected Content-Type: %s. Expected: %s",
+				contentType,
+				"image/jpeg",
+			)
+		}
+		if frames[0].Time.IsZero() {
+			t.Error("Unexpected: zero frame time")
+		}
+	}
+}
+
+func TestParseWithContentLength(t *testing.T) {
+	input := bytes.Join(
+		[][]byte{
+			[]byte("--ffmpeg"),
+			[]byte("Content-type: image/jpeg"),
+			[]byte("Content-length: 8"),
+			[]byte(""),
+			[]byte("ban\r\nana"),
+			[]byte("--ffmpeg"),
+			[]byte("Content-type: image/jpeg"),
+			[]byte("Content-length: 5"),
+			[]byte(""),
+			[]byte("apple"),
+		},
+		[]byte("\r\n"),
+	)
+	frames := parseHelper(input, 3)
+	if len(frames) != 2 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+	}
+	if string(frames[0].Data) != "ban\r\nana" {
+		t.Errorf(
+			"Unexpected frame data: %q. Expected: %q",
+			frames[0].Data,
+			"ban\r\nana",
+		)
+	}
+	if string(frames[1].Data) != "apple" {
+		t.Errorf("Unexpected frame data: %s. Expected: %s", frames[1].Data, "apple")
+	}
+}
+
+func TestParseWithRestart(t *testing.T) {
+	input := bytes.Join(
+		[][]byte{
+			[]byte("--ffmpeg"),
+			[]byte(""),
+			[]byte("banana"),
+			[]byte("--ffmpeg--"),
+			[]byte("--ffmpeg"),
+			[]byte(""),
+			[]byte("ban"),
+			[]byte("--ffmpegx"),
+			[]byte("ana"),
+			[]byte("--ffmpeg"),
+			[]byte(""),
+			[]byte("apple"),
+			[]byte("--ffmpeg--"),
+			[]byte(""),
+		},
+		[]byte("\r\n"),
+	)
+	frames := parseHelper(input, 5)
+	if len(frames) != 3 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 3)
+	}
+	expected := []string{"banana", "ban\r\n--ffmpegx\r\nana", "apple"}
+	for i, frame := range frames {
+		if string(frame.Data) != expected[i] {
+			t.Errorf(
+				"Unexpected frame data: %q. Expected: %q",
+				frame.Data,
+				expected[i],
+			)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	f := &Frame{
+		Header: textproto.MIMEHeader{
+			"Content-Type":   {"image/jpeg"},
+			"Content-Length": {"1"},
+			"X-Banana":       {"apple"},
+		},
+		Data:     []byte("banana"),
+		Time:     time.Date(2020, 5, 1, 12, 0, 0, 500, time.UTC),
+		Sequence: 42,
+	}
+	expectedOutput := strings.Join(
+		[]string{
+			"--ffmpeg",
+			"Content-Type: image/jpeg",
+			"X-Banana: apple",
+			"Content-Length: 6",
+			"X-Timestamp: 2020-05-01T12:00:00.0000005Z",
+			"X-Sequence: 42",
+			"",
+			"banana",
+			"",
+		},
+		"\r\n",
+	)
+	output := string(f.Encode("ffmpeg"))
+	if output != expectedOutput {
+		t.Errorf("Unexpected output: %q. Expected: %q", output, expectedOutput)
+	}
+	frames := parseHelper([]byte(output+"--ffmpeg--\r\n"), 5)
+	if len(frames) != 1 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 1)
+	}
+	if string(frames[0].Data) != "banana" {
+		t.Errorf(
+			"Unexpected frame data: %s. Expected: %s",
+			frames[0].Data,
+			"banana",
+		)
+	}
+	if frames[0].Header.Get("X-Sequence") != "42" {
+		t.Errorf(
+			"Unexpected X-Sequence header: %s. Expected: %s",
+			frames[0].Header.Get("X-Sequence"),
+			"42",
+		)
+	}
+}
+
+func TestEncodeWithoutSequence(t *testing.T) {
+	f := &Frame{
+		Header: textproto.MIMEHeader{"X-Sequence": {"42"}},
+		Data:   []byte("banana"),
+		Time:   time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	expectedOutput := strings.Join(
+		[]string{
+			"--ffmpeg",
+			"Content-Type: image/jpeg",
+			"Content-Length: 6",
+			"X-Timestamp: 2020-05-01T12:00:00Z",
+			"",
+			"banana",
+			"",
+		},
+		"\r\n",
+	)
+	output := string(f.Encode("ffmpeg"))
+	if output != expectedOutput {
+		t.Errorf("Unexpected output: %q. Expected: %q", output, expectedOutput)
+	}
+}
+
+func TestParseWithBoundaryDetection(t *testing.T) {
+	input := bytes.Join(
+		[][]byte{
+			[]byte("preamble"),
+			[]byte("--banana"),
+			[]byte(""),
+			[]byte("apple"),
+			[]byte("--banana"),
+			[]byte(""),
+			[]byte("orange"),
+			[]byte("--banana--"),
+			[]byte(""),
+		},
+		[]byte("\r\n"),
+	)
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	frames := parseHelper(input, 4)
+	if len(frames) != 2 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+	}
+	if string(frames[1].Data) != "orange" {
+		t.Errorf(
+			"Unexpected frame data: %s. Expected: %s",
+			frames[1].Data,
+			"orange",
+		)
+	}
+	expectedOutput := `Detected multipart boundary "banana" differs from ` +
+		`configured boundary "ffmpeg"`
+	if !strings.Contains(output.String(), expectedOutput) {
+		t.Errorf(
+			"Unexpected log output: %s. Expected: %s",
+			output.String(),
+			expectedOutput,
+		)
+	}
+	output.Reset()
+	frames = nil
+	parser := NewParser("", func(f *Frame) {
+		frames = append(frames, f)
+	})
+	parser.Write(input)
+	if len(frames) != 2 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+	}
+	if output.Len() != 0 {
+		t.Errorf("Unexpected log output: %s", output.String())
+	}
+}
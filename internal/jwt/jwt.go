@@ -0,0 +1,306 @@
+/*
+Package jwt implements the verification of JSON Web Tokens signed with HS256,
+RS256 or EdDSA, using keys from PEM or JWKS files.
+See also: https://tools.ietf.org/html/rfc7519
+*/
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/auth"
+)
+
+// Supported signature algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// QueryParam is the URL query parameter to pass a token without header.
+const QueryParam = "access_token"
+
+// Errors returned by Verify.
+var (
+	ErrMissing = errors.New("missing token")
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("expired token")
+)
+
+// Claims are the verified token claims.
+// Streams lists the URL paths of the streams the token grants access to.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Expires   int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Streams   []string `json:"streams"`
+}
+
+// Allows returns true if the claims grant access to the stream with the given
+// URL path.
+func (c *Claims) Allows(path string) bool {
+	for _, stream := range c.Streams {
+		if stream == path {
+			return true
+		}
+	}
+	return false
+}
+
+type key struct {
+	id    string
+	alg   string
+	value interface{}
+}
+
+// Keys is a set of keys to verify token signatures.
+type Keys struct {
+	keys []key
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwk is a JSON Web Key, see also: https://tools.ietf.org/html/rfc7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+func newKey(id string, value interface{}) (key, error) {
+	switch value.(type) {
+	case []byte:
+		return key{id, HS256, value}, nil
+	case *rsa.PublicKey:
+		return key{id, RS256, value}, nil
+	case ed25519.PublicKey:
+		return key{id, EdDSA, value}, nil
+	}
+	return key{}, fmt.Errorf("unsupported key type: %T", value)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+func parseJWK(k jwk) (key, error) {
+	switch {
+	case k.Kty == "oct":
+		secret, err := decodeSegment(k.K)
+		if err != nil || len(secret) == 0 {
+			return key{}, errors.New("invalid oct key: " + k.Kid)
+		}
+		return newKey(k.Kid, secret)
+	case k.Kty == "RSA":
+		n, errN := decodeSegment(k.N)
+		e, errE := decodeSegment(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+			return key{}, errors.New("invalid RSA key: " + k.Kid)
+		}
+		return newKey(k.Kid, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		})
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return key{}, errors.New("invalid Ed25519 key: " + k.Kid)
+		}
+		return newKey(k.Kid, ed25519.PublicKey(x))
+	}
+	return key{}, fmt.Errorf("unsupported key type: %s %s", k.Kty, k.Crv)
+}
+
+func parseJWKS(content []byte) ([]key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]key, len(set.Keys))
+	for i, k := range set.Keys {
+		var err error
+		keys[i], err = parseJWK(k)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func parsePEM(content []byte) ([]key, error) {
+	var keys []key
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		var value interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			value, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			value, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				value = cert.PublicKey
+			}
+		default:
+			err = errors.New("unsupported PEM block: " + block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		k, err := newKey("", value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Load reads the keys from the given file, either in JWKS format or as PEM
+// encoded public keys or certificates.
+// HS256 secrets are only supported as JWKS keys of type "oct".
+func Load(path string) (*Keys, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []key
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		keys, err = parseJWKS(content)
+	} else {
+		keys, err = parsePEM(content)
+	}
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	if len(keys) == 0 {
+		return nil, errors.New(path + ": no keys found")
+	}
+	return &Keys{keys}, nil
+}
+
+func verify(value interface{}, signed []byte, signature []byte) bool {
+	switch value := value.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, value)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		err := rsa.VerifyPKCS1v15(value, crypto.SHA256, digest[:], signature)
+		return err == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(value, signed, signature)
+	}
+	return false
+}
+
+// Verify checks the signature and validity period of the given token at the
+// given time and returns its claims.
+// Only keys matching the algorithm and, if given, the key ID of the token
+// header are considered.
+func (k *Keys) Verify(token string, now time.Time) (*Claims, error) {
+	if token == "" {
+		return nil, ErrMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	var h header
+	content, err := decodeSegment(parts[0])
+	if err != nil || json.Unmarshal(content, &h) != nil {
+		return nil, ErrInvalid
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range k.keys {
+		if key.alg != h.Alg {
+			continue
+		}
+		if h.Kid != "" && key.id != "" && key.id != h.Kid {
+			continue
+		}
+		if verify(key.value, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalid
+	}
+	claims := &Claims{}
+	content, err = decodeSegment(parts[1])
+	if err != nil || json.Unmarshal(content, claims) != nil {
+		return nil, ErrInvalid
+	}
+	if claims.Expires != 0 && now.Unix() >= claims.Expires {
+		return nil, ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, ErrInvalid
+	}
+	return claims, nil
+}
+
+// FromRequest returns the token passed via bearer authorization header or
+// access_token query parameter.
+// See also: https://tools.ietf.org/html/rfc6750#section-2
+func FromRequest(req *http.Request) string {
+	authorization := req.Header.Get("Authorization")
+	prefix := "Bearer "
+	if len(authorization) > len(prefix) &&
+		strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return strings.TrimSpace(authorization[len(prefix):])
+	}
+	return req.URL.Query().Get(QueryParam)
+}
+
+// Challenge responds with a 401 status and a bearer authentication challenge,
+// which includes the reason if a token was given but not accepted.
+func Challenge(res http.ResponseWriter, err error) {
+	challenge := `Bearer realm="` + auth.Realm + `"`
+	if err != nil && err != ErrMissing {
+		challenge += `, error="invalid_token", error_description="` +
+			err.Error() + `"`
+	}
+	res.Header().Set("WWW-Authenticate", challenge)
+	res.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,185 @@
+/*
+Package limit implements global, per-stream and per-IP client connection
+limits.
+*/
+package limit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/metrics"
+)
+
+// Scopes of the connection limits, reported as reason for rejections.
+const (
+	Global = "global"
+	Stream = "stream"
+	IP     = "ip"
+)
+
+var (
+	clientsGauge = metrics.NewGauge(
+		"mjpeg_clients",
+		"Number of connected clients.",
+	)
+	clientsLimitGauge = metrics.NewGauge(
+		"mjpeg_clients_limit",
+		"Maximum number of connected clients (0 if unlimited).",
+	)
+	streamClientsGauge = metrics.NewGauge(
+		"mjpeg_stream_clients",
+		"Number of connected clients per stream.",
+		"stream",
+	)
+	streamClientsLimitGauge = metrics.NewGauge(
+		"mjpeg_stream_clients_limit",
+		"Maximum number of connected clients per stream (0 if unlimited).",
+		"stream",
+	)
+	ipClientsGauge = metrics.NewGauge(
+		"mjpeg_ip_clients_max",
+		"Highest number of connected clients from a single IP.",
+	)
+	ipClientsLimitGauge = metrics.NewGauge(
+		"mjpeg_ip_clients_limit",
+		"Maximum number of connected clients per IP (0 if unlimited).",
+	)
+	rejectedCounter = metrics.NewCounter(
+		"mjpeg_rejected_clients_total",
+		"Number of clients rejected due to connection limits.",
+		"limit",
+	)
+)
+
+type logEntry struct {
+	ID       string
+	Time     time.Time
+	Stream   string
+	RemoteIP string
+	Limit    string
+	Max      int
+}
+
+type limiter struct {
+	max          int
+	maxPerIP     int
+	streamLimits map[string]int
+	clients      int
+	streams      map[string]int
+	ips          map[string]int
+	lock         *sync.Mutex
+}
+
+// Limiter is an interface to enforce client connection limits.
+// The Acquire method registers a client connection if no limit is exceeded and
+// returns a function to release it again.
+type Limiter interface {
+	SetStreamLimit(stream string, max int)
+	Acquire(id string, stream string, ip string) (release func(), ok bool)
+}
+
+func log(id string, stream string, ip string, limit string, max int) {
+	entry := &logEntry{
+		ID:       id,
+		Time:     time.Now().UTC(),
+		Stream:   stream,
+		RemoteIP: ip,
+		Limit:    limit,
+		Max:      max,
+	}
+	b, _ := json.Marshal(entry)
+	fmt.Println(string(b))
+}
+
+func reject(id string, stream string, ip string, limit string, max int) {
+	rejectedCounter.Inc(limit)
+	log(id, stream, ip, limit, max)
+}
+
+// updateGauges sets the client count gauges. Must be called with the lock held.
+func (l *limiter) updateGauges(stream string) {
+	clientsGauge.Set(float64(l.clients))
+	streamClientsGauge.Set(float64(l.streams[stream]), stream)
+	highest := 0
+	for _, num := range l.ips {
+		if num > highest {
+			highest = num
+		}
+	}
+	ipClientsGauge.Set(float64(highest))
+}
+
+// SetStreamLimit sets the maximum number of clients for the given stream.
+// A zero value disables the limit.
+func (l *limiter) SetStreamLimit(stream string, max int) {
+	l.lock.Lock()
+	l.streamLimits[stream] = max
+	streamClientsGauge.Set(float64(l.streams[stream]), stream)
+	streamClientsLimitGauge.Set(float64(max), stream)
+	l.lock.Unlock()
+}
+
+// Acquire registers a client connection for the given stream and IP.
+// If a limit is exceeded, the rejection is logged for the given request ID and
+// ok is false.
+func (l *limiter) Acquire(id string, stream string, ip string) (
+	release func(),
+	ok bool,
+) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	streamMax := l.streamLimits[stream]
+	switch {
+	case l.max > 0 && l.clients >= l.max:
+		reject(id, stream, ip, Global, l.max)
+		return nil, false
+	case streamMax > 0 && l.streams[stream] >= streamMax:
+		reject(id, stream, ip, Stream, streamMax)
+		return nil, false
+	case l.maxPerIP > 0 && ip != "" && l.ips[ip] >= l.maxPerIP:
+		reject(id, stream, ip, IP, l.maxPerIP)
+		return nil, false
+	}
+	l.clients++
+	l.streams[stream]++
+	if ip != "" {
+		l.ips[ip]++
+	}
+	l.updateGauges(stream)
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			l.lock.Lock()
+			l.clients--
+			l.streams[stream]--
+			if ip != "" {
+				l.ips[ip]--
+				if l.ips[ip] == 0 {
+					delete(l.ips, ip)
+				}
+			}
+			l.updateGauges(stream)
+			l.lock.Unlock()
+		})
+	}
+	return release, true
+}
+
+// New creates a new Limiter with the given global and per-IP limits.
+// Zero values disable the respective limit.
+func New(max int, maxPerIP int) Limiter {
+	clientsLimitGauge.Set(float64(max))
+	ipClientsLimitGauge.Set(float64(maxPerIP))
+	return &limiter{
+		max,
+		maxPerIP,
+		make(map[string]int),
+		0,
+		make(map[string]int),
+		make(map[string]int),
+		&sync.Mutex{},
+	}
+}
@@ -0,0 +1,110 @@
+package limit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func outputHelper(fn func()) (stdout []byte, stderr []byte) {
+	outReader, outWriter, _ := os.Pipe()
+	errReader, errWriter, _ := os.Pipe()
+	originalOut := os.Stdout
+	originalErr := os.Stderr
+	os.Stdout = outWriter
+	os.Stderr = errWriter
+	fn()
+	outWriter.Close()
+	errWriter.Close()
+	stdout, _ = ioutil.ReadAll(outReader)
+	stderr, _ = ioutil.ReadAll(errReader)
+	os.Stdout = originalOut
+	os.Stderr = originalErr
+	return
+}
+
+func TestNew(t *testing.T) {
+	l := New(0, 0)
+	if l == nil {
+		t.Error("Unexpected: nil")
+	}
+	_, ok := interface{}(l).(Limiter)
+	if !ok {
+		t.Error("Unexpected: not a Limiter")
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	l := New(3, 1)
+	l.SetStreamLimit("/", 2)
+	release1, ok := l.Acquire("1", "/", "192.0.2.1")
+	if !ok {
+		t.Fatal("Unexpected rejection")
+	}
+	var entry logEntry
+	stdout, _ := outputHelper(func() {
+		_, ok = l.Acquire("2", "/", "192.0.2.1")
+	})
+	if ok {
+		t.Error("Unexpected acquisition beyond the per-IP limit")
+	}
+	json.Unmarshal(stdout, &entry)
+	if entry.ID != "2" || entry.Limit != IP || entry.Max != 1 {
+		t.Errorf("Unexpected log entry: %+v", entry)
+	}
+	release2, ok := l.Acquire("3", "/", "192.0.2.2")
+	if !ok {
+		t.Fatal("Unexpected rejection")
+	}
+	stdout, _ = outputHelper(func() {
+		_, ok = l.Acquire("4", "/", "192.0.2.3")
+	})
+	if ok {
+		t.Error("Unexpected acquisition beyond the stream limit")
+	}
+	entry = logEntry{}
+	json.Unmarshal(stdout, &entry)
+	if entry.Limit != Stream || entry.Max != 2 || entry.Stream != "/" {
+		t.Errorf("Unexpected log entry: %+v", entry)
+	}
+	release3, ok := l.Acquire("5", "/banana", "")
+	if !ok {
+		t.Fatal("Unexpected rejection")
+	}
+	stdout, _ = outputHelper(func() {
+		_, ok = l.Acquire("6", "/apple", "")
+	})
+	if ok {
+		t.Error("Unexpected acquisition beyond the global limit")
+	}
+	entry = logEntry{}
+	json.Unmarshal(stdout, &entry)
+	if entry.Limit != Global || entry.Max != 3 {
+		t.Errorf("Unexpected log entry: %+v", entry)
+	}
+	if value := clientsGauge.Value(); value != 3 {
+		t.Errorf("Unexpected clients gauge: %g. Expected: %g", value, 3.0)
+	}
+	if value := streamClientsGauge.Value("/"); value != 2 {
+		t.Errorf("Unexpected stream clients gauge: %g. Expected: %g", value, 2.0)
+	}
+	if value := rejectedCounter.Value(Global); value != 1 {
+		t.Errorf("Unexpected rejected counter: %g. Expected: %g", value, 1.0)
+	}
+	release1()
+	// Releasing twice must not change the counts.
+	release1()
+	release2()
+	release3()
+	if value := clientsGauge.Value(); value != 0 {
+		t.Errorf("Unexpected clients gauge: %g. Expected: %g", value, 0.0)
+	}
+	if value := ipClientsGauge.Value(); value != 0 {
+		t.Errorf("Unexpected IP clients gauge: %g. Expected: %g", value, 0.0)
+	}
+	_, ok = l.Acquire("7", "/", "192.0.2.1")
+	if !ok {
+		t.Error("Unexpected rejection after release")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Package listener creates network listeners for TCP addresses, Unix domain
+sockets and sockets passed via systemd socket activation.
+*/
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed via systemd socket activation.
+// See also: https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+var listenFdsStart = 3
+
+// Systemd is the address to use listeners passed via socket activation.
+const Systemd = "systemd"
+
+// UnixPrefix is the address prefix for Unix domain sockets.
+const UnixPrefix = "unix:"
+
+func listenSystemd() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed via systemd")
+	}
+	num, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || num < 1 {
+		return nil, errors.New("no sockets passed via systemd")
+	}
+	// Prevent child processes from inheriting the environment variables.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	listeners := make([]net.Listener, num)
+	for i := 0; i < num; i++ {
+		fd := listenFdsStart + i
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listeners[i], err = net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %s", fd, err)
+		}
+	}
+	return listeners, nil
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil &&
+		info.Mode()&os.ModeSocket != 0 {
+		// Remove a stale socket file left by a previous process.
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		err = os.Chmod(path, mode)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Listen creates the listeners for the given address, which can be a TCP
+// address, a Unix domain socket path with "unix:" prefix or "systemd" to use
+// the sockets passed via systemd socket activation.
+// Unix domain socket files are created with the given file mode, unless it is
+// zero.
+func Listen(address string, mode os.FileMode) ([]net.Listener, error) {
+	if address == Systemd {
+		return listenSystemd()
+	}
+	var (
+		l   net.Listener
+		err error
+	)
+	if strings.HasPrefix(address, UnixPrefix) {
+		l, err = listenUnix(strings.TrimPrefix(address, UnixPrefix), mode)
+	} else {
+		l, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{l}, nil
+}
@@ -0,0 +1,89 @@
+package listener
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenTCP(t *testing.T) {
+	listeners, err := Listen("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listeners[0].Close()
+	if len(listeners) != 1 {
+		t.Errorf("Unexpected listeners: %d. Expected: %d", len(listeners), 1)
+	}
+	network := listeners[0].Addr().Network()
+	if network != "tcp" {
+		t.Errorf("Unexpected network: %s. Expected: %s", network, "tcp")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "listener")
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "mjpeg.sock")
+	listeners, err := Listen("unix:"+path, 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	network := listeners[0].Addr().Network()
+	if network != "unix" {
+		t.Errorf("Unexpected network: %s. Expected: %s", network, "unix")
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf(
+			"Unexpected file mode: %s. Expected: %s",
+			info.Mode().Perm(),
+			os.FileMode(0600),
+		)
+	}
+	// Simulate a stale socket file left by a crashed process.
+	listeners[0].(*net.UnixListener).SetUnlinkOnClose(false)
+	listeners[0].Close()
+	listeners, err = Listen("unix:"+path, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	listeners[0].Close()
+	file, _ := os.Create(path)
+	file.Close()
+	_, err = Listen("unix:"+path, 0)
+	if err == nil {
+		t.Error("Unexpected removal of a regular file")
+	}
+}
+
+func TestListenSystemd(t *testing.T) {
+	_, err := Listen(Systemd, 0)
+	if err == nil {
+		t.Error("Unexpected nil error without socket activation")
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	file, _ := l.(*net.TCPListener).File()
+	listenFdsStart = int(file.Fd())
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err := Listen(Systemd, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listeners[0].Close()
+	if listeners[0].Addr().String() != l.Addr().String() {
+		t.Errorf(
+			"Unexpected address: %s. Expected: %s",
+			listeners[0].Addr(),
+			l.Addr(),
+		)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Unexpected LISTEN_FDS environment variable")
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Package mask implements privacy masks, which hide rectangular regions of JPEG
+frames.
+*/
+package mask
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Styles of the masked regions.
+const (
+	// Fill covers the region with solid black.
+	Fill = "fill"
+	// Pixelate replaces the region with blocks of their average color.
+	Pixelate = "pixelate"
+)
+
+// Size of the blocks of pixelated regions in pixels.
+const blockSize = 16
+
+// JPEG quality of masked frames.
+const quality = 90
+
+// coordinate is an absolute value in pixels or a percentage of the image size.
+type coordinate struct {
+	value    float64
+	relative bool
+}
+
+// Mask is a rectangular region of a frame to hide with the given style.
+type Mask struct {
+	spec   string
+	x      coordinate
+	y      coordinate
+	width  coordinate
+	height coordinate
+	style  string
+}
+
+// Masks is the list of privacy masks of a stream.
+type Masks []Mask
+
+type store struct {
+	masks Masks
+	lock  *sync.RWMutex
+}
+
+// Store is an interface to share masks which can be changed at runtime.
+// The Masks method returns the current masks, while the Set method replaces
+// them and returns the previous masks.
+type Store interface {
+	Masks() Masks
+	Set(masks Masks) (previous Masks)
+}
+
+// resolve returns the coordinate in pixels for the given image size.
+func (c coordinate) resolve(size int) int {
+	if c.relative {
+		return int(c.value*float64(size)/100 + 0.5)
+	}
+	return int(c.value)
+}
+
+func parseCoordinate(value string) (coordinate, error) {
+	c := coordinate{}
+	if strings.HasSuffix(value, "%") {
+		c.relative = true
+		value = strings.TrimSuffix(value, "%")
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 || (c.relative && number > 100) {
+		return c, fmt.Errorf("invalid coordinate: %s", value)
+	}
+	c.value = number
+	return c, nil
+}
+
+// Parse creates Masks from strings in the format
+// "x,y,width,height[,fill|pixelate]", e.g. "0,90%,100%,10%,pixelate".
+// Coordinates are given in pixels or as percentage of the image size.
+// Regions are filled with solid black unless the style is set.
+func Parse(specs []string) (Masks, error) {
+	masks := make(Masks, len(specs))
+	for i, spec := range specs {
+		parts := strings.Split(spec, ",")
+		if len(parts) != 4 && len(parts) != 5 {
+			return nil, fmt.Errorf("invalid mask: %s", spec)
+		}
+		var coordinates [4]coordinate
+		for j := range coordinates {
+			c, err := parseCoordinate(strings.TrimSpace(parts[j]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid mask %s: %s", spec, err)
+			}
+			coordinates[j] = c
+		}
+		if coordinates[2].value == 0 || coordinates[3].value == 0 {
+			return nil, fmt.Errorf("invalid mask %s: empty region", spec)
+		}
+		style := Fill
+		if len(parts) == 5 {
+			style = strings.TrimSpace(parts[4])
+			if style != Fill && style != Pixelate {
+				return nil, fmt.Errorf("invalid mask %s: unknown style", spec)
+			}
+		}
+		masks[i] = Mask{
+			spec,
+			coordinates[0],
+			coordinates[1],
+			coordinates[2],
+			coordinates[3],
+			style,
+		}
+	}
+	return masks, nil
+}
+
+// String returns the mask in the format accepted by Parse.
+func (m Mask) String() string {
+	return m.spec
+}
+
+// Rect returns the masked region for an image with the given bounds.
+func (m Mask) Rect(bounds image.Rectangle) image.Rectangle {
+	x := bounds.Min.X + m.x.resolve(bounds.Dx())
+	y := bounds.Min.Y + m.y.resolve(bounds.Dy())
+	return image.Rect(
+		x,
+		y,
+		x+m.width.resolve(bounds.Dx()),
+		y+m.height.resolve(bounds.Dy()),
+	).Intersect(bounds)
+}
+
+// Specs returns the masks in the format accepted by Parse.
+func (m Masks) Specs() []string {
+	specs := make([]string, len(m))
+	for i, mask := range m {
+		specs[i] = mask.spec
+	}
+	return specs
+}
+
+// Equal reports whether the masks are the same as the given masks.
+func (m Masks) Equal(other Masks) bool {
+	if len(m) != len(other) {
+		return false
+	}
+	for i, mask := range m {
+		if mask != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pixelate replaces the given region with blocks of their average color.
+func pixelate(img *image.RGBA, rect image.Rectangle) {
+	for y := rect.Min.Y; y < rect.Max.Y; y += blockSize {
+		for x := rect.Min.X; x < rect.Max.X; x += blockSize {
+			block := image.Rect(x, y, x+blockSize, y+blockSize).Intersect(rect)
+			var r, g, b, n int
+			for by := block.Min.Y; by < block.Max.Y; by++ {
+				for bx := block.Min.X; bx < block.Max.X; bx++ {
+					i := img.PixOffset(bx, by)
+					r += int(img.Pix[i])
+					g += int(img.Pix[i+1])
+					b += int(img.Pix[i+2])
+					n++
+				}
+			}
+			average := color.RGBA{
+				uint8(r / n),
+				uint8(g / n),
+				uint8(b / n),
+				255,
+			}
+			draw.Draw(img, block, &image.Uniform{average}, image.Point{}, draw.Src)
+		}
+	}
+}
+
+// Apply returns the given JPEG data with the masked regions hidden.
+// The data is returned unchanged if no mask overlaps the image.
+func (m Masks) Apply(data []byte) ([]byte, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	visible := make(Masks, 0, len(m))
+	for _, mask := range m {
+		if !mask.Rect(bounds).Empty() {
+			visible = append(visible, mask)
+		}
+	}
+	if len(visible) == 0 {
+		return data, nil
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, decoded, decoded.Bounds().Min, draw.Src)
+	for _, mask := range visible {
+		rect := mask.Rect(bounds)
+		if mask.style == Pixelate {
+			pixelate(img, rect)
+		} else {
+			draw.Draw(img, rect, image.Black, image.Point{}, draw.Src)
+		}
+	}
+	var buffer bytes.Buffer
+	err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Masks returns the current masks.
+func (s *store) Masks() Masks {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.masks
+}
+
+// Set replaces the current masks and returns the previous masks.
+func (s *store) Set(masks Masks) (previous Masks) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous = s.masks
+	s.masks = masks
+	return
+}
+
+// NewStore creates a new Store with the given initial masks.
+func NewStore(masks Masks) Store {
+	return &store{masks, &sync.RWMutex{}}
+}
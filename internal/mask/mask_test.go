@@ -0,0 +1,139 @@
+package mask
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+func jpegHelper() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.RGBA{255, 255, 255, 255})
+		}
+	}
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, nil)
+	return buffer.Bytes()
+}
+
+// luminanceHelper returns the 8 bit luminance of the pixel at x, y.
+func luminanceHelper(img image.Image, x, y int) uint8 {
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+func TestParse(t *testing.T) {
+	specs := []string{"0,90%,100%,10%,pixelate", "10, 20, 30, 40"}
+	masks, err := Parse(specs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(masks.Specs(), specs) {
+		t.Errorf("Unexpected specs: %v. Expected: %v", masks.Specs(), specs)
+	}
+	bounds := image.Rect(0, 0, 200, 100)
+	for i, expected := range []image.Rectangle{
+		image.Rect(0, 90, 200, 100),
+		image.Rect(10, 20, 40, 60),
+	} {
+		if rect := masks[i].Rect(bounds); rect != expected {
+			t.Errorf("Unexpected rect: %v. Expected: %v", rect, expected)
+		}
+	}
+	for _, spec := range []string{
+		"",
+		"0,0,10",
+		"0,0,10,10,blur",
+		"0,0,0,10",
+		"-1,0,10,10",
+		"0,0,101%,10",
+		"a,0,10,10",
+	} {
+		if _, err := Parse([]string{spec}); err == nil {
+			t.Errorf("Unexpected nil error for spec: %s", spec)
+		}
+	}
+	if specs := (Masks{}).Specs(); specs == nil || len(specs) != 0 {
+		t.Errorf("Unexpected specs for no masks: %v", specs)
+	}
+}
+
+func TestApply(t *testing.T) {
+	data := jpegHelper()
+	masks, _ := Parse([]string{"0,0,50%,50%", "50%,0,50%,50%,pixelate"})
+	masked, err := masks.Apply(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(masked))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, test := range []struct {
+		x, y int
+		min  uint8
+		max  uint8
+	}{
+		{10, 10, 0, 16},     // Filled with black.
+		{150, 10, 240, 255}, // Pixelated white.
+		{100, 90, 240, 255}, // Not masked.
+	} {
+		value := luminanceHelper(img, test.x, test.y)
+		if value < test.min || value > test.max {
+			t.Errorf(
+				"Unexpected luminance at %d,%d: %d. Expected: %d-%d",
+				test.x,
+				test.y,
+				value,
+				test.min,
+				test.max,
+			)
+		}
+	}
+	outside, _ := Parse([]string{"300,0,10,10"})
+	unchanged, err := outside.Apply(data)
+	if err != nil || !bytes.Equal(unchanged, data) {
+		t.Errorf("Unexpected modification for masks outside the image")
+	}
+	if _, err := masks.Apply([]byte("banana")); err == nil {
+		t.Error("Unexpected nil error for invalid data")
+	}
+}
+
+func TestStore(t *testing.T) {
+	initial, _ := Parse([]string{"0,0,10,10"})
+	updated, _ := Parse([]string{"0,0,20,20"})
+	store := NewStore(initial)
+	previous := store.Set(updated)
+	if !reflect.DeepEqual(previous, initial) {
+		t.Errorf("Unexpected previous masks: %v. Expected: %v", previous, initial)
+	}
+	if !reflect.DeepEqual(store.Masks(), updated) {
+		t.Errorf("Unexpected masks: %v. Expected: %v", store.Masks(), updated)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	masks, _ := Parse([]string{"0,0,10,10", "0,90%,100%,10%,pixelate"})
+	same, _ := Parse([]string{"0,0,10,10", "0,90%,100%,10%,pixelate"})
+	if !masks.Equal(same) {
+		t.Errorf("Unexpected inequality: %v. Expected: %v", masks, same)
+	}
+	if !Masks(nil).Equal(Masks{}) {
+		t.Error("Unexpected inequality of empty masks")
+	}
+	for _, specs := range [][]string{
+		{"0,0,10,10"},
+		{"0,0,10,10", "0,90%,100%,10%"},
+		{"0,90%,100%,10%,pixelate", "0,0,10,10"},
+	} {
+		other, _ := Parse(specs)
+		if masks.Equal(other) {
+			t.Errorf("Unexpected equality: %v. Expected: %v", other, masks)
+		}
+	}
+}
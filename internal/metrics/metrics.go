@@ -0,0 +1,165 @@
+/*
+Package metrics implements gauges and counters, which are exposed in the
+Prometheus text exposition format.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+type metric struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	samples    map[string]*sample
+	lock       *sync.Mutex
+}
+
+// Gauge is a metric with a value that can go up and down, partitioned by the
+// label values given on each call.
+type Gauge struct {
+	*metric
+}
+
+// Counter is a metric with a value that only goes up, partitioned by the label
+// values given on each call.
+type Counter struct {
+	*metric
+}
+
+var (
+	metrics       []*metric
+	lock          = &sync.Mutex{}
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func register(name, help, kind string, labelNames []string) *metric {
+	m := &metric{
+		name,
+		help,
+		kind,
+		labelNames,
+		make(map[string]*sample),
+		&sync.Mutex{},
+	}
+	lock.Lock()
+	metrics = append(metrics, m)
+	lock.Unlock()
+	return m
+}
+
+func (m *metric) update(fn func(value float64) float64, labelValues []string) {
+	key := strings.Join(labelValues, "\xff")
+	m.lock.Lock()
+	s, ok := m.samples[key]
+	if !ok {
+		s = &sample{labelValues: labelValues}
+		m.samples[key] = s
+	}
+	s.value = fn(s.value)
+	m.lock.Unlock()
+}
+
+// Delete removes the sample with the given label values.
+func (m *metric) Delete(labelValues ...string) {
+	m.lock.Lock()
+	delete(m.samples, strings.Join(labelValues, "\xff"))
+	m.lock.Unlock()
+}
+
+// Value returns the current value for the given label values.
+func (m *metric) Value(labelValues ...string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s, ok := m.samples[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (m *metric) write(w io.Writer) {
+	m.lock.Lock()
+	keys := make([]string, 0, len(m.samples))
+	for key := range m.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	for _, key := range keys {
+		s := m.samples[key]
+		labels := make([]string, len(m.labelNames))
+		for i, name := range m.labelNames {
+			value := ""
+			if i < len(s.labelValues) {
+				value = s.labelValues[i]
+			}
+			labels[i] = name + `="` + labelReplacer.Replace(value) + `"`
+		}
+		if len(labels) > 0 {
+			fmt.Fprintf(w, "%s{%s} ", m.name, strings.Join(labels, ","))
+		} else {
+			fmt.Fprintf(w, "%s ", m.name)
+		}
+		fmt.Fprintln(w, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	m.lock.Unlock()
+}
+
+// Set sets the Gauge to the given value.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.update(func(float64) float64 { return value }, labelValues)
+}
+
+// Add adds the given value to the Gauge.
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.update(func(v float64) float64 { return v + value }, labelValues)
+}
+
+// Inc increments the Counter by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.update(func(v float64) float64 { return v + 1 }, labelValues)
+}
+
+// NewGauge creates and registers a new Gauge.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{register(name, help, "gauge", labelNames)}
+}
+
+// NewCounter creates and registers a new Counter.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{register(name, help, "counter", labelNames)}
+}
+
+// Write outputs all registered metrics in the Prometheus text format.
+func Write(w io.Writer) {
+	lock.Lock()
+	registered := append([]*metric(nil), metrics...)
+	lock.Unlock()
+	sort.Slice(registered, func(i, j int) bool {
+		return registered[i].name < registered[j].name
+	})
+	for _, m := range registered {
+		m.write(w)
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	res.Header().Set("Cache-Control", "no-store")
+	Write(res)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGauge(t *testing.T) {
+	gauge := NewGauge("test_gauge", "Test gauge.", "stream")
+	gauge.Set(3, "/")
+	gauge.Add(-1, "/")
+	gauge.Set(1, "/banana")
+	value := gauge.Value("/")
+	if value != 2 {
+		t.Errorf("Unexpected value: %g. Expected: %g", value, 2.0)
+	}
+	value = gauge.Value("/apple")
+	if value != 0 {
+		t.Errorf("Unexpected value: %g. Expected: %g", value, 0.0)
+	}
+	gauge.Delete("/banana")
+	var buffer bytes.Buffer
+	Write(&buffer)
+	expectedOutput := strings.Join(
+		[]string{
+			"# HELP test_gauge Test gauge.",
+			"# TYPE test_gauge gauge",
+			`test_gauge{stream="/"} 2`,
+			"",
+		},
+		"\n",
+	)
+	if !strings.Contains(buffer.String(), expectedOutput) {
+		t.Errorf(
+			"Unexpected output: %s. Expected: %s",
+			buffer.String(),
+			expectedOutput,
+		)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter("test_counter", "Test counter.")
+	counter.Inc()
+	counter.Inc()
+	var buffer bytes.Buffer
+	Write(&buffer)
+	expectedOutput := strings.Join(
+		[]string{
+			"# HELP test_counter Test counter.",
+			"# TYPE test_counter counter",
+			"test_counter 2",
+			"",
+		},
+		"\n",
+	)
+	if !strings.Contains(buffer.String(), expectedOutput) {
+		t.Errorf(
+			"Unexpected output: %s. Expected: %s",
+			buffer.String(),
+			expectedOutput,
+		)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	gauge := NewGauge("test_handler", "Test handler.", "stream")
+	gauge.Set(1, "\"ban\\ana\"\n")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:9000/metrics", nil)
+	Handler(rec, req)
+	header := rec.Header().Get("Content-Type")
+	expectedHeader := "text/plain; version=0.0.4"
+	if header != expectedHeader {
+		t.Errorf(
+			"Unexpected Content-Type header: %s. Expected: %s",
+			header,
+			expectedHeader,
+		)
+	}
+	expectedLine := `test_handler{stream="\"ban\\ana\"\n"} 1`
+	if !strings.Contains(rec.Body.String(), expectedLine) {
+		t.Errorf(
+			"Unexpected output: %s. Expected: %s",
+			rec.Body.String(),
+			expectedLine,
+		)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Package mosaic implements combining the frames of multiple streams into a
+labeled grid.
+*/
+package mosaic
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"sync"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+	"github.com/blueimp/mjpeg-server/internal/resize"
+	"github.com/blueimp/mjpeg-server/internal/text"
+)
+
+// Default mosaic settings, used for zero Options values.
+const (
+	DefaultWidth = 1280
+	DefaultFPS   = 2
+)
+
+// MaxFPS is the maximum frame rate of a mosaic.
+const MaxFPS = 30
+
+// JPEG quality of the mosaic frames.
+const quality = 80
+
+// Background color of tiles without frames and of letterboxed tiles.
+var background = color.RGBA{32, 32, 32, 255}
+
+// Options configures a Mosaic.
+// Frames are arranged in a grid with the given number of Columns, which is
+// chosen to form a square grid if 0. Tiles have a 16:9 aspect ratio, with the
+// total Width of the grid given in pixels. Each tile is labeled with the label
+// at the same index.
+type Options struct {
+	Labels  []string
+	Columns int
+	Width   int
+}
+
+// tile is the scaled image of a source frame.
+type tile struct {
+	frame *frame.Frame
+	img   image.Image
+}
+
+type mosaic struct {
+	options Options
+	rows    int
+	size    image.Point
+	tiles   []tile
+	lock    *sync.Mutex
+}
+
+// Mosaic is an interface to combine frames into a grid.
+// The Render method returns the JPEG data of the grid for the given frames,
+// which are given in the order of the labels, with nil for missing frames.
+type Mosaic interface {
+	Render(frames []*frame.Frame) ([]byte, error)
+}
+
+// scale returns the image scaled to fit into the given size, keeping its
+// aspect ratio.
+func scale(img image.Image, size image.Point) image.Image {
+	bounds := img.Bounds()
+	ratio := math.Min(
+		float64(size.X)/float64(bounds.Dx()),
+		float64(size.Y)/float64(bounds.Dy()),
+	)
+	width := int(float64(bounds.Dx()) * ratio)
+	height := int(float64(bounds.Dy()) * ratio)
+	return resize.Box(img, width, height)
+}
+
+// tileImage returns the scaled image of the frame at the given index.
+// Images are cached until the frame changes, while frames which cannot be
+// decoded result in a nil image.
+func (m *mosaic) tileImage(index int, f *frame.Frame) image.Image {
+	if f == nil {
+		return nil
+	}
+	cached := m.tiles[index]
+	if cached.frame == f {
+		return cached.img
+	}
+	img, err := jpeg.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		m.tiles[index] = tile{f, nil}
+		return nil
+	}
+	scaled := scale(img, m.size)
+	m.tiles[index] = tile{f, scaled}
+	return scaled
+}
+
+// Render returns the JPEG data of the grid for the given frames.
+func (m *mosaic) Render(frames []*frame.Frame) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	columns := m.options.Columns
+	img := image.NewRGBA(image.Rect(0, 0, columns*m.size.X, m.rows*m.size.Y))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	textScale := m.size.X / 320
+	if textScale < 1 {
+		textScale = 1
+	}
+	for i, label := range m.options.Labels {
+		origin := image.Point{i % columns * m.size.X, i / columns * m.size.Y}
+		rect := image.Rectangle{origin, origin.Add(m.size)}
+		// Leave a one pixel gap between tiles.
+		inner := image.Rectangle{rect.Min, rect.Max.Sub(image.Point{1, 1})}
+		draw.Draw(img, inner, &image.Uniform{background}, image.Point{}, draw.Src)
+		var f *frame.Frame
+		if i < len(frames) {
+			f = frames[i]
+		}
+		if tileImg := m.tileImage(i, f); tileImg != nil {
+			// Center the scaled frame within the tile.
+			size := tileImg.Bounds().Size()
+			min := origin.Add(m.size.Sub(size).Div(2))
+			target := image.Rectangle{min, min.Add(size)}
+			draw.Draw(img, target.Intersect(inner), tileImg, image.Point{}, draw.Src)
+		}
+		height := text.Height(textScale) + 4*textScale
+		box := image.Rect(
+			origin.X,
+			origin.Y,
+			origin.X+text.Width(label, textScale)+4*textScale,
+			origin.Y+height,
+		).Intersect(inner)
+		draw.Draw(img, box, image.Black, image.Point{}, draw.Src)
+		text.Draw(
+			img,
+			label,
+			image.Point{origin.X + 2*textScale, origin.Y + 2*textScale},
+			textScale,
+		)
+	}
+	var buffer bytes.Buffer
+	err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// New creates a new Mosaic.
+func New(options Options) Mosaic {
+	n := len(options.Labels)
+	if n == 0 {
+		n = 1
+	}
+	if options.Columns <= 0 {
+		options.Columns = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	if options.Columns > n {
+		options.Columns = n
+	}
+	if options.Width <= 0 {
+		options.Width = DefaultWidth
+	}
+	width := options.Width / options.Columns
+	if width < 16 {
+		width = 16
+	}
+	return &mosaic{
+		options,
+		(n + options.Columns - 1) / options.Columns,
+		image.Point{width, width * 9 / 16},
+		make([]tile, len(options.Labels)),
+		&sync.Mutex{},
+	}
+}
@@ -0,0 +1,105 @@
+package mosaic
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+)
+
+func frameHelper(c color.Color, width, height int) *frame.Frame {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, nil)
+	return &frame.Frame{Data: buffer.Bytes()}
+}
+
+func TestRender(t *testing.T) {
+	m := New(Options{Labels: []string{"a", "b", "c"}, Width: 640})
+	red := frameHelper(color.RGBA{255, 0, 0, 255}, 160, 90)
+	blue := frameHelper(color.RGBA{0, 0, 255, 255}, 90, 90)
+	data, err := m.Render([]*frame.Frame{red, blue, nil})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// Three tiles are arranged in a 2x2 grid of 320x180 tiles.
+	bounds := img.Bounds()
+	if bounds.Dx() != 640 || bounds.Dy() != 360 {
+		t.Fatalf("Unexpected size: %v", bounds)
+	}
+	for _, test := range []struct {
+		x     int
+		y     int
+		red   bool
+		blue  bool
+		label string
+	}{
+		{160, 120, true, false, "full size red tile"},
+		{480, 120, false, true, "centered blue tile"},
+		{340, 120, false, false, "letterbox of the blue tile"},
+		{160, 300, false, false, "tile without frame"},
+		{480, 300, false, false, "empty grid cell"},
+	} {
+		r, _, b, _ := img.At(test.x, test.y).RGBA()
+		if (r > 0xC000) != test.red || (b > 0xC000) != test.blue {
+			t.Errorf("Unexpected color of the %s: %v", test.label, img.At(
+				test.x,
+				test.y,
+			))
+		}
+	}
+	// Labels are drawn in white in the top left corner of each tile.
+	var found bool
+	for y := 0; y < 20 && !found; y++ {
+		for x := 320; x < 340; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r > 0xC000 && g > 0xC000 &&
+				b > 0xC000 {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		t.Error("Unexpected: label not found")
+	}
+	cached, _ := m.Render([]*frame.Frame{red, blue, nil})
+	if !bytes.Equal(cached, data) {
+		t.Error("Unexpected: different data for the same frames")
+	}
+}
+
+func TestColumns(t *testing.T) {
+	for _, test := range []struct {
+		options Options
+		width   int
+		height  int
+	}{
+		{Options{Labels: make([]string, 8), Width: 300}, 300, 168},
+		{Options{Labels: make([]string, 8), Columns: 4, Width: 400}, 400, 112},
+		{Options{Labels: make([]string, 2), Columns: 4, Width: 400}, 400, 112},
+	} {
+		data, err := New(test.options).Render(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		config, _ := jpeg.DecodeConfig(bytes.NewReader(data))
+		if config.Width != test.width || config.Height != test.height {
+			t.Errorf(
+				"Unexpected size: %dx%d. Expected: %dx%d",
+				config.Width,
+				config.Height,
+				test.width,
+				test.height,
+			)
+		}
+	}
+}
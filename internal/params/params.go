@@ -0,0 +1,83 @@
+/*
+Package params implements command argument templates, which are filled from
+allow-listed and validated request query parameters.
+*/
+package params
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Param is an allowed query parameter with the pattern its values must match.
+type Param struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// Params is the list of allowed query parameters.
+type Params []Param
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Parse creates Params from strings in the format "name=regex", e.g.
+// "display=:[0-9]+". The regular expression must match the complete value.
+func Parse(specs []string) (Params, error) {
+	parsed := make(Params, len(specs))
+	for i, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || !namePattern.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid parameter: %s", spec)
+		}
+		pattern, err := regexp.Compile("^(?:" + parts[1] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter pattern: %s", spec)
+		}
+		parsed[i] = Param{parts[0], pattern}
+	}
+	return parsed, nil
+}
+
+// Values returns the values of the allowed parameters from the given query.
+// Missing parameters have an empty value, which must also match the pattern.
+func (p Params) Values(query url.Values) (map[string]string, error) {
+	values := make(map[string]string, len(p))
+	for _, param := range p {
+		value := query.Get(param.Name)
+		if !param.Pattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid value for parameter %s", param.Name)
+		}
+		values[param.Name] = value
+	}
+	return values, nil
+}
+
+// Key returns a canonical string representation of the given values, which
+// identifies the parameter set.
+func Key(values map[string]string) string {
+	query := url.Values{}
+	for name, value := range values {
+		query.Set(name, value)
+	}
+	return query.Encode()
+}
+
+// Expand replaces the "{name}" placeholders in the given args with the given
+// values. Placeholders for unknown names are kept as is.
+func Expand(args []string, values map[string]string) []string {
+	if len(values) == 0 {
+		return args
+	}
+	replacements := make([]string, 0, 2*len(values))
+	for name, value := range values {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = replacer.Replace(arg)
+	}
+	return expanded
+}
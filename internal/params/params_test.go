@@ -0,0 +1,85 @@
+package params
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]string{"display=:[0-9]+", "size=[0-9]+x[0-9]+|"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(p) != 2 || p[0].Name != "display" || p[1].Name != "size" {
+		t.Errorf("Unexpected params: %v", p)
+	}
+	for _, spec := range []string{"display", "=.*", "a b=.*", "display=["} {
+		if _, err := Parse([]string{spec}); err == nil {
+			t.Errorf("Unexpected nil error for spec: %s", spec)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	p, _ := Parse([]string{"display=:[0-9]+", "size=[0-9]+x[0-9]+|"})
+	tests := []struct {
+		query  string
+		values map[string]string
+	}{
+		{
+			"display=:3&size=1280x720&banana=apple",
+			map[string]string{"display": ":3", "size": "1280x720"},
+		},
+		{"display=:3", map[string]string{"display": ":3", "size": ""}},
+		{"display=:3;rm", nil},
+		{"display=x:3", nil},
+		{"size=1280x720", nil},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		values, err := p.Values(query)
+		if test.values == nil {
+			if err == nil {
+				t.Errorf("Unexpected nil error for query: %s", test.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for query %s: %s", test.query, err)
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf(
+				"Unexpected values: %v. Expected: %v",
+				values,
+				test.values,
+			)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	key := Key(map[string]string{"size": "1280x720", "display": ":3"})
+	expected := "display=%3A3&size=1280x720"
+	if key != expected {
+		t.Errorf("Unexpected key: %s. Expected: %s", key, expected)
+	}
+	if Key(nil) != "" {
+		t.Errorf("Unexpected key for no values: %s", Key(nil))
+	}
+}
+
+func TestExpand(t *testing.T) {
+	args := []string{"-i", "{display}.0", "-s", "{size}", "{unknown}"}
+	expanded := Expand(args, map[string]string{
+		"display": ":3",
+		"size":    "1280x720",
+	})
+	expected := []string{"-i", ":3.0", "-s", "1280x720", "{unknown}"}
+	if !reflect.DeepEqual(expanded, expected) {
+		t.Errorf("Unexpected args: %v. Expected: %v", expanded, expected)
+	}
+	if args[1] != "{display}.0" {
+		t.Errorf("Unexpected modification of args: %v", args)
+	}
+}
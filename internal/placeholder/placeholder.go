@@ -0,0 +1,177 @@
+/*
+Package placeholder implements JPEG images, which are sent to clients instead of
+the stream while the source is unavailable.
+*/
+package placeholder
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // Register the PNG format for image files.
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/text"
+)
+
+// Status texts for the states without live frames.
+const (
+	Starting   = "starting"
+	Restarting = "restarting"
+	Failed     = "source failed"
+)
+
+// DefaultInterval is the default time between placeholder frames.
+const DefaultInterval = time.Second
+
+// Size of the generated card.
+const (
+	cardWidth  = 640
+	cardHeight = 360
+)
+
+// Title drawn onto the generated card.
+const title = "NO SIGNAL"
+
+// Options configures a Placeholder.
+// Image is the path to a JPEG or PNG file, a card is generated if empty.
+// If Status is true, the status text is drawn onto the image.
+type Options struct {
+	Image    string
+	Status   bool
+	Interval time.Duration
+}
+
+type placeholder struct {
+	options    Options
+	background image.Image
+	card       bool
+	images     map[string][]byte
+	lock       *sync.Mutex
+}
+
+// Placeholder is an interface to provide placeholder images.
+// The JPEG method returns the encoded image for the given status text, while
+// the Interval method returns the time between placeholder frames.
+type Placeholder interface {
+	JPEG(status string) []byte
+	Interval() time.Duration
+}
+
+// Color bars of the generated card.
+var bars = []color.RGBA{
+	{192, 192, 192, 255},
+	{192, 192, 0, 255},
+	{0, 192, 192, 255},
+	{0, 192, 0, 255},
+	{192, 0, 192, 255},
+	{192, 0, 0, 255},
+	{0, 0, 192, 255},
+}
+
+// drawText draws the given text in white, horizontally centered at the given
+// vertical position.
+func drawText(img draw.Image, s string, y int, scale int) {
+	bounds := img.Bounds()
+	x := bounds.Min.X + (bounds.Dx()-text.Width(s, scale))/2
+	text.Draw(img, s, image.Point{x, y}, scale)
+}
+
+// card generates the color bars background.
+func card() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	for i, bar := range bars {
+		rect := image.Rect(
+			i*cardWidth/len(bars),
+			0,
+			(i+1)*cardWidth/len(bars),
+			cardHeight,
+		)
+		draw.Draw(img, rect, &image.Uniform{bar}, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// render draws the title and status text onto a copy of the background.
+// Text is drawn onto black boxes, which are scaled with the image width.
+func (p *placeholder) render(status string) []byte {
+	bounds := p.background.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), p.background, bounds.Min, draw.Src)
+	scale := bounds.Dx() / 160
+	if scale < 1 {
+		scale = 1
+	}
+	if p.card {
+		height := 11 * scale
+		y := bounds.Dy()/2 - height
+		box := image.Rect(0, y, bounds.Dx(), y+height)
+		draw.Draw(img, box, image.Black, image.Point{}, draw.Src)
+		drawText(img, title, y+2*scale, scale)
+	}
+	if p.options.Status && status != "" {
+		statusScale := scale/2 + 1
+		height := 11 * statusScale
+		y := bounds.Dy() - 2*height
+		if p.card {
+			y = bounds.Dy() / 2
+		}
+		box := image.Rect(0, y, bounds.Dx(), y+height)
+		draw.Draw(img, box, image.Black, image.Point{}, draw.Src)
+		drawText(img, status, y+2*statusScale, statusScale)
+	}
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 80})
+	return buffer.Bytes()
+}
+
+// JPEG returns the encoded placeholder image for the given status text.
+// Images are rendered once per status text.
+func (p *placeholder) JPEG(status string) []byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	data, ok := p.images[status]
+	if !ok {
+		data = p.render(status)
+		p.images[status] = data
+	}
+	return data
+}
+
+// Interval returns the time between placeholder frames.
+func (p *placeholder) Interval() time.Duration {
+	return p.options.Interval
+}
+
+// New creates a new Placeholder, reading the image file if given.
+func New(options Options) (Placeholder, error) {
+	if options.Interval <= 0 {
+		options.Interval = DefaultInterval
+	}
+	p := &placeholder{
+		options,
+		nil,
+		options.Image == "",
+		make(map[string][]byte),
+		&sync.Mutex{},
+	}
+	if p.card {
+		p.background = card()
+		return p, nil
+	}
+	file, err := os.Open(options.Image)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p.background, _, err = image.Decode(file)
+	if err != nil {
+		return nil, errors.New(options.Image + ": " + err.Error())
+	}
+	return p, nil
+}
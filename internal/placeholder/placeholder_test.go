@@ -0,0 +1,93 @@
+package placeholder
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func decodeHelper(t *testing.T, data []byte) image.Image {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return img
+}
+
+func TestCard(t *testing.T) {
+	p, err := New(Options{Status: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if p.Interval() != DefaultInterval {
+		t.Errorf(
+			"Unexpected interval: %s. Expected: %s",
+			p.Interval(),
+			DefaultInterval,
+		)
+	}
+	data := p.JPEG(Starting)
+	bounds := decodeHelper(t, data).Bounds()
+	if bounds.Dx() != cardWidth || bounds.Dy() != cardHeight {
+		t.Errorf("Unexpected size: %v", bounds)
+	}
+	if !bytes.Equal(p.JPEG(Starting), data) {
+		t.Error("Unexpected: different image for the same status")
+	}
+	if bytes.Equal(p.JPEG(Failed), data) {
+		t.Error("Unexpected: same image for different status")
+	}
+}
+
+func TestStatusDisabled(t *testing.T) {
+	p, _ := New(Options{Interval: time.Minute})
+	if p.Interval() != time.Minute {
+		t.Errorf("Unexpected interval: %s. Expected: %s", p.Interval(), time.Minute)
+	}
+	if !bytes.Equal(p.JPEG(Starting), p.JPEG(Failed)) {
+		t.Error("Unexpected: different image with disabled status")
+	}
+}
+
+func TestImage(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "placeholder")
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "image.png")
+	file, _ := os.Create(path)
+	png.Encode(file, image.NewGray(image.Rect(0, 0, 320, 240)))
+	file.Close()
+	p, err := New(Options{Image: path, Status: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	img := decodeHelper(t, p.JPEG(Restarting))
+	if img.Bounds().Dx() != 320 || img.Bounds().Dy() != 240 {
+		t.Errorf("Unexpected size: %v", img.Bounds())
+	}
+	// The status text is drawn in white near the bottom of the image.
+	var found bool
+	for y := 200; y < 240 && !found; y++ {
+		for x := 0; x < 320; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r > 0xC000 {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		t.Error("Unexpected: no status text")
+	}
+	ioutil.WriteFile(path, []byte("banana"), 0600)
+	if _, err := New(Options{Image: path}); err == nil {
+		t.Error("Unexpected nil error for invalid image")
+	}
+	if _, err := New(Options{Image: "invalid"}); err == nil {
+		t.Error("Unexpected nil error for missing file")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Package quality implements reduced quality variants of JPEG frames for clients
+which cannot keep up with the stream.
+*/
+package quality
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strconv"
+
+	"github.com/blueimp/mjpeg-server/internal/resize"
+)
+
+// level is a variant with the given relative size and JPEG quality.
+type level struct {
+	scale   int
+	quality int
+}
+
+// Levels in descending order of quality, with the scale given in percent.
+// Level 0 is the original frame.
+var levels = [...]level{
+	{100, 0},
+	{75, 70},
+	{50, 50},
+	{25, 40},
+}
+
+// MaxLevel is the level with the lowest quality.
+const MaxLevel = len(levels) - 1
+
+// Key returns the identifier of the given level, e.g. for derived streams.
+func Key(level int) string {
+	return "quality=" + strconv.Itoa(level)
+}
+
+// scale returns the image scaled down to the given percentage of its size.
+func scale(img image.Image, percent int) *image.RGBA {
+	bounds := img.Bounds()
+	width := bounds.Dx() * percent / 100
+	height := bounds.Dy() * percent / 100
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return resize.Box(img, width, height)
+}
+
+// Apply returns the given JPEG data as variant of the given level.
+// The data is returned unchanged for level 0.
+func Apply(data []byte, level int) ([]byte, error) {
+	if level < 0 || level > MaxLevel {
+		return nil, fmt.Errorf("invalid quality level: %d", level)
+	}
+	if level == 0 {
+		return data, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	err = jpeg.Encode(
+		&buffer,
+		scale(img, levels[level].scale),
+		&jpeg.Options{Quality: levels[level].quality},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
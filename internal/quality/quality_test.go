@@ -0,0 +1,65 @@
+package quality
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func jpegHelper(width, height int) []byte {
+	var buffer bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 95})
+	return buffer.Bytes()
+}
+
+func TestApply(t *testing.T) {
+	data := jpegHelper(400, 200)
+	unchanged, err := Apply(data, 0)
+	if err != nil || !bytes.Equal(unchanged, data) {
+		t.Errorf("Unexpected result for level 0: %v", err)
+	}
+	size := len(data)
+	for level, width := range []int{400, 300, 200, 100} {
+		if level == 0 {
+			continue
+		}
+		variant, err := Apply(data, level)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(variant))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if config.Width != width || config.Height != width/2 {
+			t.Errorf(
+				"Unexpected size for level %d: %dx%d. Expected: %dx%d",
+				level,
+				config.Width,
+				config.Height,
+				width,
+				width/2,
+			)
+		}
+		if len(variant) >= size {
+			t.Errorf("Unexpected data size for level %d: %d", level, len(variant))
+		}
+		size = len(variant)
+	}
+	for _, level := range []int{-1, MaxLevel + 1} {
+		if _, err := Apply(data, level); err == nil {
+			t.Errorf("Unexpected nil error for level %d", level)
+		}
+	}
+	if _, err := Apply([]byte("banana"), 1); err == nil {
+		t.Error("Unexpected nil error for invalid data")
+	}
+	if key := Key(2); key != "quality=2" {
+		t.Errorf("Unexpected key: %s. Expected: %s", key, "quality=2")
+	}
+}
@@ -7,46 +7,240 @@ package recording
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/event"
+	"github.com/blueimp/mjpeg-server/internal/metrics"
 )
 
 var exitStatusZero error
 
+var stallCounter = metrics.NewCounter(
+	"mjpeg_stream_stalls_total",
+	"Number of recording restarts due to stalled streams.",
+	"stream",
+)
+
 // WaitFunc waits for the command execution to stop.
 // It returns an error explaining the stop.
 type WaitFunc func() error
 
-// StartFunc executes the recording command with the given args and writes the
-// output to the provided writer. It returns a function to stop the recording
-// and a function to wait for the recording to stop.
-type StartFunc func(command string, args []string, w io.Writer) (
+// DefaultHookTimeout is used for hooks without Timeout.
+const DefaultHookTimeout = 30 * time.Second
+
+// Defaults for stopping the recording command, used for zero Options values.
+const (
+	DefaultStopSignal  = "SIGINT"
+	DefaultStopTimeout = 5 * time.Second
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// Hooks are executables run around the recording command, with the hook name,
+// the stream name, the PID and the exit status of the recording command
+// provided via MJPEG_HOOK, MJPEG_STREAM, MJPEG_PID and MJPEG_EXIT_STATUS
+// environment variables.
+// A failing PreStart hook aborts the start of the recording command.
+// Each hook is killed if it runs longer than the Timeout.
+type Hooks struct {
+	PreStart  string
+	PostStart string
+	PostStop  string
+	Timeout   time.Duration
+}
+
+// Options configures the recording command.
+// Name identifies the recorded stream in emitted events and hooks.
+// The command is stopped by sending the StopSignal to its process group and
+// killed if it is still running after the StopTimeout.
+// If StallTimeout is set, the command is restarted when no value is received
+// from the Heartbeat channel within the timeout, e.g. for each complete frame.
+// OnCrash is called if the command stopped unexpectedly.
+type Options struct {
+	Name         string
+	Command      string
+	Args         []string
+	Hooks        Hooks
+	StopSignal   string
+	StopTimeout  time.Duration
+	StallTimeout time.Duration
+	Heartbeat    <-chan struct{}
+	OnCrash      func(err error, restart bool)
+}
+
+func signalName(name string) string {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
+
+// ParseSignal returns the signal with the given name, e.g. "SIGTERM" or
+// "TERM".
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = signalName(name)
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal: %s", name)
+	}
+	return sig, nil
+}
+
+// StartFunc executes the recording command with the given options and writes
+// the output to the provided writer. It returns a function to stop the
+// recording and a function to wait for the recording to stop.
+type StartFunc func(options Options, w io.Writer) (
 	stop context.CancelFunc,
 	wait WaitFunc,
 )
 
+// crash logs and emits the unexpected stop of the recording command.
+func crash(options Options, err error, restart bool) {
+	log.Println(err)
+	event.Emit("recording-crash", options.Name, map[string]interface{}{
+		"Error":   fmt.Sprint(err),
+		"Restart": restart,
+	})
+	if options.OnCrash != nil {
+		options.OnCrash(err, restart)
+	}
+}
+
+// runHook executes the given hook with the given environment variables.
+// It returns nil if the hook is empty.
+func runHook(options Options, name string, hook string, env ...string) error {
+	if hook == "" {
+		return nil
+	}
+	timeout := options.Hooks.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Env = append(
+		append(os.Environ(), "MJPEG_HOOK="+name, "MJPEG_STREAM="+options.Name),
+		env...,
+	)
+	// STDOUT is reserved for the JSON logs.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timeout after %s", timeout)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s hook failed: %s", name, err)
+		log.Println(err)
+	}
+	return err
+}
+
+// terminate stops the command gracefully with the configured signal and kills
+// it if it does not exit within the stop timeout.
+// It returns the method used to stop the command.
+func terminate(cmd *exec.Cmd, options Options, exited chan struct{}) string {
+	name := DefaultStopSignal
+	if options.StopSignal != "" {
+		name = signalName(options.StopSignal)
+	}
+	timeout := options.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	sig, err := ParseSignal(name)
+	if err == nil && sig != syscall.SIGKILL {
+		err = signalGroup(cmd, sig)
+		if err == nil {
+			select {
+			case <-exited:
+				return name
+			case <-time.After(timeout):
+				killGroup(cmd)
+				return "SIGKILL after " + timeout.String() + " timeout"
+			}
+		}
+	}
+	killGroup(cmd)
+	return "SIGKILL"
+}
+
+// watch closes the stalled channel if no heartbeat is received within the stall
+// timeout, until the command exited.
+func watch(options Options, exited chan struct{}, stalled chan struct{}) {
+	timer := time.NewTimer(options.StallTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-options.Heartbeat:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(options.StallTimeout)
+		case <-timer.C:
+			log.Printf("Recording stalled: no frame within %s", options.StallTimeout)
+			stallCounter.Inc(options.Name)
+			close(stalled)
+			return
+		case <-exited:
+			return
+		}
+	}
+}
+
 func run(
 	ctx context.Context,
-	command string,
-	args []string,
+	options Options,
 	w io.Writer,
 	status chan error,
 ) {
-	cmd := exec.CommandContext(ctx, command, args...)
+	err := runHook(options, "pre-start", options.Hooks.PreStart)
+	if err != nil {
+		crash(options, err, false)
+		status <- err
+		close(status)
+		return
+	}
+	if ctx.Err() != nil {
+		// Stopped while running the pre-start hook.
+		status <- ctx.Err()
+		close(status)
+		return
+	}
+	cmd := exec.Command(options.Command, options.Args...)
+	// Run the command in its own process group to be able to stop any child
+	// processes along with it.
+	setProcessGroup(cmd)
 	cmd.Stderr = os.Stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Println(err)
+		crash(options, err, false)
 		status <- err
 		close(status)
 		return
 	}
 	err = cmd.Start()
 	if err != nil {
-		log.Println(err)
+		crash(options, err, false)
 		status <- err
 		close(status)
 		return
@@ -54,30 +248,87 @@ func run(
 	go io.Copy(w, stdout)
 	startTime := time.Now()
 	log.Println("Recording started")
+	pid := strconv.Itoa(cmd.Process.Pid)
+	event.Emit("recording-start", options.Name, map[string]interface{}{
+		"PID": cmd.Process.Pid,
+	})
+	exited := make(chan struct{})
+	stalled := make(chan struct{})
+	if options.StallTimeout > 0 && options.Heartbeat != nil {
+		go watch(options, exited, stalled)
+	}
+	method := make(chan string, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			method <- terminate(cmd, options, exited)
+		case <-stalled:
+			method <- terminate(cmd, options, exited)
+		case <-exited:
+			method <- ""
+		}
+	}()
+	// Run the post-start hook in the background, so that a slow hook does not
+	// delay stopping the command.
+	started := make(chan struct{})
+	go func() {
+		runHook(options, "post-start", options.Hooks.PostStart, "MJPEG_PID="+pid)
+		close(started)
+	}()
 	err = cmd.Wait()
-	log.Println("Recording stopped")
+	close(exited)
+	// Make sure no child processes of the command are left behind.
+	killGroup(cmd)
+	var stopData map[string]interface{}
+	stopMethod := <-method
+	if stopMethod != "" {
+		log.Println("Recording stopped via", stopMethod)
+		stopData = map[string]interface{}{"Method": stopMethod}
+	} else {
+		log.Println("Recording stopped")
+	}
+	// Keep the order of the hooks.
+	<-started
+	runHook(
+		options,
+		"post-stop",
+		options.Hooks.PostStop,
+		"MJPEG_PID="+pid,
+		"MJPEG_EXIT_STATUS="+strconv.Itoa(cmd.ProcessState.ExitCode()),
+	)
 	canceled := ctx.Err()
+	if canceled == nil && stopMethod != "" {
+		// Command was stopped by the watchdog, restart.
+		crash(options, fmt.Errorf("stalled, stopped via %s", stopMethod), true)
+		run(ctx, options, w, status)
+		return
+	}
 	if err != exitStatusZero && canceled != context.Canceled {
 		// Command has stopped unexpectedly.
-		log.Println(err)
 		if time.Since(startTime).Seconds() > 1 {
 			// Command ran long enough for this not to be an argument error, restart.
-			run(ctx, command, args, w, status)
-		} else {
-			status <- err
-			close(status)
+			crash(options, err, true)
+			run(ctx, options, w, status)
+			return
 		}
+		crash(options, err, false)
+		event.Emit("recording-stop", options.Name, nil)
+		status <- err
+		close(status)
 	} else {
+		event.Emit("recording-stop", options.Name, stopData)
 		status <- canceled
 		close(status)
 	}
 }
 
-// Start executes the recording command with the given args and writes the
+// Start executes the recording command with the given options and writes the
 // output to the provided writer. It returns a function to stop the recording
 // and a function to wait for the recording to stop.
 // If the recording command fails unexpectedly, it is restarted automatically.
-func Start(command string, args []string, w io.Writer) (
+// The "recording-start", "recording-crash" and "recording-stop" events are
+// emitted for the lifecycle of the command.
+func Start(options Options, w io.Writer) (
 	stop context.CancelFunc,
 	wait WaitFunc,
 ) {
@@ -86,6 +337,6 @@ func Start(command string, args []string, w io.Writer) (
 	wait = func() error {
 		return <-status
 	}
-	go run(ctx, command, args, w, status)
+	go run(ctx, options, w, status)
 	return
 }
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -36,7 +37,7 @@ func TestStart(t *testing.T) {
 	args := []string{"run", mpjpegPath, "-n", filePath}
 	imageData, _ := ioutil.ReadFile(filePath)
 	var buffer bytes.Buffer
-	stop, wait := Start(command, args, &buffer)
+	stop, wait := Start(Options{Command: command, Args: args}, &buffer)
 	if stop == nil {
 		t.Error("Unexpected: stop function is nil")
 	}
@@ -85,7 +86,7 @@ func TestStartWithCancel(t *testing.T) {
 	imageData, _ := ioutil.ReadFile(filePath)
 	var buffer bytes.Buffer
 	args := []string{"run", mpjpegPath, "-s", "1000ms", filePath}
-	stop, wait := Start(command, args, &buffer)
+	stop, wait := Start(Options{Command: command, Args: args}, &buffer)
 	go func() {
 		time.Sleep(1500 * time.Millisecond)
 		stop()
@@ -128,7 +129,7 @@ func TestStartWithRestart(t *testing.T) {
 	args := []string{"run", mpjpegPath, "-n", "-s", "1000ms", filePath}
 	imageData, _ := ioutil.ReadFile(filePath)
 	var buffer bytes.Buffer
-	stop, wait := Start(command, args, &buffer)
+	stop, wait := Start(Options{Command: command, Args: args}, &buffer)
 	go func() {
 		time.Sleep(2000 * time.Millisecond)
 		stop()
@@ -168,7 +169,7 @@ func TestStartWithInvalidCommand(t *testing.T) {
 	command := "./invalid"
 	args := []string{}
 	var buffer bytes.Buffer
-	_, wait := Start(command, args, &buffer)
+	_, wait := Start(Options{Command: command, Args: args}, &buffer)
 	err := wait()
 	if err == nil {
 		t.Error("Unexpected nil error")
@@ -179,9 +180,120 @@ func TestStartWithShortDurationCommand(t *testing.T) {
 	command := "go"
 	args := []string{"version"}
 	var buffer bytes.Buffer
-	_, wait := Start(command, args, &buffer)
+	_, wait := Start(Options{Command: command, Args: args}, &buffer)
 	err := wait()
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
 }
+
+func writeHookHelper(dir string, name string, script string) string {
+	path := filepath.Join(dir, name)
+	ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700)
+	return path
+}
+
+func TestStartWithHooks(t *testing.T) {
+	exitStatusZero = nil
+	tmpDir, _ := ioutil.TempDir("", "hooks")
+	defer os.RemoveAll(tmpDir)
+	logPath := filepath.Join(tmpDir, "hooks.log")
+	script := `echo "$MJPEG_HOOK $MJPEG_STREAM $MJPEG_PID $MJPEG_EXIT_STATUS"` +
+		" >> " + logPath
+	options := Options{
+		Name:    "/",
+		Command: "go",
+		Args:    []string{"version"},
+		Hooks: Hooks{
+			PreStart:  writeHookHelper(tmpDir, "pre-start", script),
+			PostStart: writeHookHelper(tmpDir, "post-start", script),
+			PostStop:  writeHookHelper(tmpDir, "post-stop", script),
+		},
+	}
+	var buffer bytes.Buffer
+	_, wait := Start(options, &buffer)
+	if err := wait(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	output, _ := ioutil.ReadFile(logPath)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Unexpected hook log: %s", output)
+	}
+	if strings.TrimSpace(lines[0]) != "pre-start /" {
+		t.Errorf("Unexpected pre-start hook log: %s", lines[0])
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) != 3 || fields[0] != "post-start" {
+		t.Errorf("Unexpected post-start hook log: %s", lines[1])
+	}
+	expected := strings.Join([]string{"post-stop", "/", fields[2], "0"}, " ")
+	if lines[2] != expected {
+		t.Errorf(
+			"Unexpected post-stop hook log: %s. Expected: %s",
+			lines[2],
+			expected,
+		)
+	}
+}
+
+func TestStartWithSlowPostStartHook(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "hooks")
+	defer os.RemoveAll(tmpDir)
+	logPath := filepath.Join(tmpDir, "hooks.log")
+	command := writeHookHelper(
+		tmpDir,
+		"command",
+		"trap 'echo command >> "+logPath+"; exit 0' INT\n"+
+			"while true; do sleep 0.01; done",
+	)
+	options := Options{
+		Command: command,
+		Hooks: Hooks{
+			PostStart: writeHookHelper(
+				tmpDir,
+				"post-start",
+				"sleep 0.5; echo post-start >> "+logPath,
+			),
+		},
+	}
+	var buffer bytes.Buffer
+	stop, wait := Start(options, &buffer)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	wait()
+	output, _ := ioutil.ReadFile(logPath)
+	expected := "command\npost-start\n"
+	if string(output) != expected {
+		t.Errorf("Unexpected hook log: %q. Expected: %q", output, expected)
+	}
+}
+
+func TestStartWithFailingPreStartHook(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "hooks")
+	defer os.RemoveAll(tmpDir)
+	startedPath := filepath.Join(tmpDir, "started")
+	options := Options{
+		Command: "touch",
+		Args:    []string{startedPath},
+		Hooks: Hooks{
+			PreStart: writeHookHelper(tmpDir, "pre-start", "exit 1"),
+		},
+	}
+	var buffer bytes.Buffer
+	_, wait := Start(options, &buffer)
+	if err := wait(); err == nil {
+		t.Error("Unexpected nil error")
+	}
+	if _, err := os.Stat(startedPath); err == nil {
+		t.Error("Unexpected start of the recording command")
+	}
+	options.Hooks = Hooks{
+		PreStart: writeHookHelper(tmpDir, "slow", "sleep 1"),
+		Timeout:  50 * time.Millisecond,
+	}
+	_, wait = Start(options, &buffer)
+	if err := wait(); err == nil {
+		t.Error("Unexpected nil error for hook timeout")
+	}
+}
@@ -0,0 +1,168 @@
+//go:build !windows
+// +build !windows
+
+package recording
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/event"
+)
+
+// aliveHelper returns true if the process with the given ID is running.
+func aliveHelper(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err == nil && strings.Contains(string(stat), ") Z ") {
+		// Zombie process, which has not been reaped yet.
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
+
+func stopHelper(t *testing.T, options Options) string {
+	methods := make(chan string, 1)
+	event.Subscribe(func(e event.Event) {
+		if e.Event == "recording-stop" && e.Stream == options.Name {
+			method, _ := e.Data["Method"].(string)
+			select {
+			case methods <- method:
+			default:
+			}
+		}
+	})
+	var buffer bytes.Buffer
+	stop, wait := Start(options, &buffer)
+	time.Sleep(500 * time.Millisecond)
+	stop()
+	wait()
+	select {
+	case method := <-methods:
+		return method
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected: no recording-stop event")
+	}
+	return ""
+}
+
+func TestStopWithSignal(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "stop")
+	defer os.RemoveAll(tmpDir)
+	pidPath := filepath.Join(tmpDir, "child.pid")
+	trappedPath := filepath.Join(tmpDir, "trapped")
+	script := writeHookHelper(tmpDir, "record", strings.Join([]string{
+		"sleep 60 &",
+		"echo $! > " + pidPath,
+		"trap 'echo SIGTERM > " + trappedPath + "; exit 0' TERM",
+		"while true; do sleep 0.05; done",
+	}, "\n"))
+	method := stopHelper(t, Options{
+		Name:       "/signal",
+		Command:    script,
+		StopSignal: "term",
+	})
+	if method != "SIGTERM" {
+		t.Errorf("Unexpected stop method: %s. Expected: %s", method, "SIGTERM")
+	}
+	trapped, _ := ioutil.ReadFile(trappedPath)
+	if strings.TrimSpace(string(trapped)) != "SIGTERM" {
+		t.Errorf("Unexpected trapped signal: %s", trapped)
+	}
+	content, _ := ioutil.ReadFile(pidPath)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatalf("Unexpected child PID: %s", content)
+	}
+	for i := 0; i < 10 && aliveHelper(pid); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if aliveHelper(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Error("Unexpected: child process still running")
+	}
+}
+
+func TestStopWithTimeout(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "stop")
+	defer os.RemoveAll(tmpDir)
+	script := writeHookHelper(tmpDir, "record", strings.Join([]string{
+		"trap '' INT",
+		"while true; do sleep 0.05; done",
+	}, "\n"))
+	method := stopHelper(t, Options{
+		Name:        "/timeout",
+		Command:     script,
+		StopTimeout: 100 * time.Millisecond,
+	})
+	expected := "SIGKILL after 100ms timeout"
+	if method != expected {
+		t.Errorf("Unexpected stop method: %s. Expected: %s", method, expected)
+	}
+}
+
+func TestStall(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "stall")
+	defer os.RemoveAll(tmpDir)
+	script := writeHookHelper(tmpDir, "record", strings.Join([]string{
+		"while true; do sleep 0.05; done",
+	}, "\n"))
+	crashes := make(chan string, 1)
+	event.Subscribe(func(e event.Event) {
+		if e.Event == "recording-crash" && e.Stream == "/stall" {
+			message, _ := e.Data["Error"].(string)
+			select {
+			case crashes <- message:
+			default:
+			}
+		}
+	})
+	var buffer bytes.Buffer
+	stop, wait := Start(Options{
+		Name:         "/stall",
+		Command:      script,
+		StallTimeout: 100 * time.Millisecond,
+		Heartbeat:    make(chan struct{}),
+	}, &buffer)
+	defer wait()
+	defer stop()
+	select {
+	case message := <-crashes:
+		expected := "stalled, stopped via SIGINT"
+		if message != expected {
+			t.Errorf("Unexpected error: %s. Expected: %s", message, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected: no recording-crash event")
+	}
+	if value := stallCounter.Value("/stall"); value < 1 {
+		t.Errorf("Unexpected stall count: %v. Expected: >= 1", value)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for name, expected := range map[string]syscall.Signal{
+		"SIGINT":  syscall.SIGINT,
+		"term":    syscall.SIGTERM,
+		"SigKill": syscall.SIGKILL,
+	} {
+		sig, err := ParseSignal(name)
+		if err != nil || sig != expected {
+			t.Errorf(
+				"Unexpected signal for %s: %v. Expected: %v",
+				name,
+				sig,
+				expected,
+			)
+		}
+	}
+	if _, err := ParseSignal("banana"); err == nil {
+		t.Error("Unexpected nil error")
+	}
+}
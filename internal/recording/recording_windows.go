@@ -0,0 +1,18 @@
+package recording
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// Windows has no process groups to signal, so the command is killed directly.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return errors.New("signals are not supported on Windows")
+}
+
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
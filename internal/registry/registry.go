@@ -8,16 +8,45 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/textproto"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/blueimp/mjpeg-server/internal/analysis"
+	"github.com/blueimp/mjpeg-server/internal/event"
+	"github.com/blueimp/mjpeg-server/internal/frame"
+	"github.com/blueimp/mjpeg-server/internal/mask"
+	"github.com/blueimp/mjpeg-server/internal/metrics"
 	"github.com/blueimp/mjpeg-server/internal/multi"
+	"github.com/blueimp/mjpeg-server/internal/placeholder"
 	"github.com/blueimp/mjpeg-server/internal/recording"
+	"github.com/blueimp/mjpeg-server/internal/timelapse"
+	"github.com/blueimp/mjpeg-server/internal/validation"
 )
 
 var startRecording = recording.Start
 
+var (
+	blackGauge = metrics.NewGauge(
+		"mjpeg_stream_black",
+		"Whether the stream shows a black or uniform screen.",
+		"stream",
+	)
+	frozenGauge = metrics.NewGauge(
+		"mjpeg_stream_frozen",
+		"Whether the stream shows a frozen screen.",
+		"stream",
+	)
+	droppedCounter = metrics.NewCounter(
+		"mjpeg_stream_dropped_frames_total",
+		"Number of frames dropped due to failed validation or masking.",
+		"stream",
+	)
+)
+
 type logEntry struct {
 	ID         string
 	Time       time.Time
@@ -25,23 +54,112 @@ type logEntry struct {
 	NumClients int
 }
 
+// Options configures a Registry.
+// Boundary is used for the output stream, while the boundary of the command
+// output is detected automatically and only compared to the optional
+// InputBoundary. Frame analysis is disabled if Analysis is nil.
+// Hooks are run around the recording command, which is stopped with the
+// StopSignal and killed after the StopTimeout.
+// The command is restarted if no complete frame arrives within the
+// StallTimeout (disabled if 0).
+// Placeholder frames are sent to the clients while the command is starting,
+// restarting or has failed, unless Placeholder is nil.
+// Frames failing the Validation are dropped (disabled if empty).
+// The regions of the current Masks are hidden on each frame, frames which
+// cannot be masked are dropped.
+// Frames of the given Buffer duration are kept in memory (disabled if 0).
+// Buffered frames are discarded if the Masks change.
+// Frames are sampled for time-lapse playback unless TimeLapse is nil.
+type Options struct {
+	Name          string
+	Command       string
+	Args          []string
+	DirectStart   bool
+	Boundary      string
+	InputBoundary string
+	Analysis      *analysis.Options
+	Hooks         recording.Hooks
+	StopSignal    string
+	StopTimeout   time.Duration
+	StallTimeout  time.Duration
+	Placeholder   placeholder.Placeholder
+	Validation    validation.Mode
+	Masks         mask.Store
+	Buffer        time.Duration
+	TimeLapse     *timelapse.Options
+}
+
+// Status describes the current state of a Registry.
+type Status struct {
+	Clients int
+	Black   bool
+	Frozen  bool
+}
+
 type registry struct {
-	command       string
-	args          []string
-	directStart   bool
+	options       Options
 	clients       multi.MapWriter
 	counter       uint64
+	detector      analysis.Detector
 	stopRecording context.CancelFunc
 	waitForStop   recording.WaitFunc
+	lock          *sync.Mutex
+	latest        *frame.Frame
+	sequence      uint64
+	updated       chan struct{}
+	heartbeat     chan struct{}
+	gapLock       *sync.Mutex
+	gapDone       chan struct{}
+	source        *registry
+	transform     TransformFunc
+	derived       map[string]*poolEntry
+	buffer        []*frame.Frame
+	sampler       timelapse.Sampler
+	playback      timelapse.Sampler
+	composite     *Composite
+	masked        mask.Masks
+}
+
+// TransformFunc returns the transformed JPEG data of a frame.
+type TransformFunc func(data []byte) ([]byte, error)
+
+// SourceFunc returns a source Registry of a composite and a function to
+// release it again.
+type SourceFunc func() (reg Registry, release func())
+
+// ComposeFunc returns the JPEG data combining the latest frames of the sources,
+// which are nil for sources without frames.
+type ComposeFunc func(frames []*frame.Frame) ([]byte, error)
+
+// Composite configures a Registry, which combines the latest frames of the
+// given Sources with the Compose function once per Interval.
+type Composite struct {
+	Sources  []SourceFunc
+	Compose  ComposeFunc
+	Interval time.Duration
 }
 
 // Registry is an interface to manage the handling of recording clients.
 // Clients can be added and removed with the Add and Remove methods, while the
 // GenerateID method returns an auto-incrementing ID.
+// The Status method returns the current client count and detected conditions,
+// while the Frame method waits for a frame matching the given criteria.
+// The Derive method returns a shared Registry for transformed frames, while
+// the Frames method returns the buffered frames of a time range.
+// The Variant method returns a shared Registry for transformed frames, which
+// are not buffered, e.g. for quality variants of the frames sent to clients.
+// The TimeLapse method returns a shared Registry, which plays back the sampled
+// frames in a loop.
 type Registry interface {
 	GenerateID() string
 	Add(id string, w io.Writer) (num int)
 	Remove(id string, w io.Writer) (num int)
+	Status() Status
+	Frame(ctx context.Context, match func(f *frame.Frame) bool) *frame.Frame
+	Derive(key string, transform TransformFunc) (reg Registry, release func())
+	Variant(key string, transform TransformFunc) (reg Registry, release func())
+	Frames(from time.Time, to time.Time) []*frame.Frame
+	TimeLapse() (reg Registry, release func())
 }
 
 func log(id string, registered bool, numClients int) {
@@ -55,11 +173,378 @@ func log(id string, registered bool, numClients int) {
 	fmt.Println(string(b))
 }
 
+func (t *registry) notify(
+	condition analysis.Condition,
+	active bool,
+	since time.Time,
+) {
+	name := string(condition) + "-end"
+	value := 0.0
+	if active {
+		name = string(condition) + "-start"
+		value = 1
+	}
+	switch condition {
+	case analysis.Black:
+		blackGauge.Set(value, t.options.Name)
+	case analysis.Frozen:
+		frozenGauge.Set(value, t.options.Name)
+	}
+	event.Emit(name, t.options.Name, map[string]interface{}{
+		"Since": since.UTC(),
+	})
+}
+
+// showPlaceholder sends placeholder frames with the given status to the
+// clients in regular intervals, until a live frame arrives or the recording is
+// stopped.
+// Placeholder frames have no sequence number, so they are sent without
+// X-Sequence header and do not interrupt the sequence of live frames.
+func (t *registry) showPlaceholder(status string) {
+	if t.options.Placeholder == nil {
+		return
+	}
+	t.gapLock.Lock()
+	if t.gapDone != nil {
+		close(t.gapDone)
+	}
+	done := make(chan struct{})
+	t.gapDone = done
+	t.gapLock.Unlock()
+	f := &frame.Frame{
+		Header: textproto.MIMEHeader{"X-Placeholder": {status}},
+		Data:   t.options.Placeholder.JPEG(status),
+	}
+	go func() {
+		ticker := time.NewTicker(t.options.Placeholder.Interval())
+		defer ticker.Stop()
+		for {
+			t.gapLock.Lock()
+			select {
+			case <-done:
+				t.gapLock.Unlock()
+				return
+			default:
+			}
+			if t.clients.Size() == 0 && !t.options.DirectStart {
+				// Recording is no longer used.
+				t.gapLock.Unlock()
+				return
+			}
+			f.Time = time.Now()
+			t.clients.Write(f.Encode(t.options.Boundary))
+			t.gapLock.Unlock()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// hidePlaceholder stops sending placeholder frames.
+// Once it returns, no further placeholder frames are written.
+func (t *registry) hidePlaceholder() {
+	t.gapLock.Lock()
+	if t.gapDone != nil {
+		close(t.gapDone)
+		t.gapDone = nil
+	}
+	t.gapLock.Unlock()
+}
+
+func (t *registry) handleCrash(err error, restart bool) {
+	if restart {
+		t.showPlaceholder(placeholder.Restarting)
+	} else {
+		t.showPlaceholder(placeholder.Failed)
+	}
+}
+
+func (t *registry) handleFrame(f *frame.Frame) {
+	if t.options.Validation != "" {
+		err := validation.Validate(f.Data, t.options.Validation)
+		if err != nil {
+			// Drop the corrupt frame and keep the last valid one.
+			droppedCounter.Inc(t.options.Name)
+			return
+		}
+	}
+	var masks mask.Masks
+	if t.options.Masks != nil {
+		if masks = t.options.Masks.Masks(); len(masks) > 0 {
+			data, err := masks.Apply(f.Data)
+			if err != nil {
+				// Drop the frame to not leak the masked regions.
+				droppedCounter.Inc(t.options.Name)
+				return
+			}
+			f.Data = data
+		}
+	}
+	t.hidePlaceholder()
+	t.lock.Lock()
+	if t.options.Masks != nil {
+		t.checkMasks(masks)
+	}
+	t.sequence++
+	f.Sequence = t.sequence
+	t.latest = f
+	if t.options.Buffer > 0 {
+		// Discard frames which are older than the buffer duration.
+		t.buffer = append(t.buffer, f)
+		oldest := f.Time.Add(-t.options.Buffer)
+		i := 0
+		for i < len(t.buffer) && t.buffer[i].Time.Before(oldest) {
+			i++
+		}
+		t.buffer = t.buffer[i:]
+	}
+	// Wake up all callers waiting for a new frame.
+	close(t.updated)
+	t.updated = make(chan struct{})
+	t.lock.Unlock()
+	// Signal the stall watchdog without blocking if a signal is pending.
+	select {
+	case t.heartbeat <- struct{}{}:
+	default:
+	}
+	t.clients.Write(f.Encode(t.options.Boundary))
+	if t.detector != nil {
+		t.detector.Process(f)
+	}
+	if t.sampler != nil {
+		t.sampler.Sample(f)
+	}
+}
+
+// checkMasks discards the buffered frames of the registry and its derived
+// registries if the given masks differ from the masks the frames were masked
+// with, as the frames might show newly masked regions.
+// The lock of the registry must be held.
+func (t *registry) checkMasks(masks mask.Masks) {
+	if masks.Equal(t.masked) {
+		return
+	}
+	t.masked = masks
+	t.discardFrames()
+}
+
+// discardFrames discards the buffered frames of the registry and its derived
+// registries. The lock of the registry must be held.
+func (t *registry) discardFrames() {
+	t.buffer = nil
+	for _, entry := range t.derived {
+		derived := entry.registry.(*registry)
+		derived.lock.Lock()
+		derived.discardFrames()
+		derived.lock.Unlock()
+	}
+}
+
+// handleDerived transforms the given frame of the source registry.
+// Placeholder frames of the source are passed on to the clients unchanged.
+func (t *registry) handleDerived(f *frame.Frame) {
+	if f.Header.Get("X-Placeholder") != "" {
+		t.clients.Write(f.Encode(t.options.Boundary))
+		return
+	}
+	data, err := t.transform(f.Data)
+	if err != nil {
+		droppedCounter.Inc(t.options.Name)
+		return
+	}
+	f.Data = data
+	t.handleFrame(f)
+}
+
+// startDerived registers the registry as client of its source registry.
+// Frames are transformed in the background, skipping source frames which
+// arrive while the previous frame is still being transformed.
+func (t *registry) startDerived() {
+	id := t.source.GenerateID()
+	frames := make(chan *frame.Frame, 1)
+	client := frame.NewParser(t.options.Boundary, func(f *frame.Frame) {
+		select {
+		case frames <- f:
+		default:
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		for f := range frames {
+			t.handleDerived(f)
+		}
+		close(done)
+	}()
+	t.source.Add(id, client)
+	t.stopRecording = func() {
+		// The client is not written to after it has been removed.
+		t.source.Remove(id, client)
+		close(frames)
+	}
+	t.waitForStop = func() error {
+		<-done
+		return nil
+	}
+}
+
+// idleClient is registered as client of the source registry during time-lapse
+// playback, to keep the recording running.
+type idleClient struct {
+	id string
+}
+
+func (c *idleClient) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// startPlayback registers the registry as client of its source registry and
+// sends the time-lapse frames of the source to the clients in a loop.
+// Frames keep their original time, which is sent as X-Timestamp header.
+func (t *registry) startPlayback() {
+	id := t.source.GenerateID()
+	client := &idleClient{id}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(t.playback.FrameInterval())
+		defer ticker.Stop()
+		var frames []*frame.Frame
+		next := 0
+		for {
+			if next == len(frames) {
+				// Start the next loop with the current samples.
+				frames = t.playback.Frames()
+				next = 0
+			}
+			if len(frames) > 0 {
+				f := *frames[next]
+				t.handleFrame(&f)
+				next++
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				close(stopped)
+				return
+			}
+		}
+	}()
+	t.source.Add(id, client)
+	t.stopRecording = func() {
+		t.source.Remove(id, client)
+		close(done)
+	}
+	t.waitForStop = func() error {
+		<-stopped
+		return nil
+	}
+}
+
+// startComposite registers the registry as client of its source registries
+// and sends the composed frames to the clients in regular intervals.
+// Frames are only composed again if a source provided a new frame.
+func (t *registry) startComposite() {
+	sources := make([]Registry, len(t.composite.Sources))
+	releases := make([]func(), len(sources))
+	clients := make([]*idleClient, len(sources))
+	for i, acquire := range t.composite.Sources {
+		sources[i], releases[i] = acquire()
+		clients[i] = &idleClient{sources[i].GenerateID()}
+		sources[i].Add(clients[i].id, clients[i])
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(t.composite.Interval)
+		defer ticker.Stop()
+		// Frame returns the latest frame without waiting for a done context.
+		latest, cancel := context.WithCancel(context.Background())
+		cancel()
+		all := func(f *frame.Frame) bool { return true }
+		frames := make([]*frame.Frame, len(sources))
+		var data []byte
+		for {
+			changed := data == nil
+			for i, source := range sources {
+				f := source.Frame(latest, all)
+				if f != frames[i] {
+					frames[i] = f
+					changed = true
+				}
+			}
+			if changed {
+				composed, err := t.composite.Compose(frames)
+				if err != nil {
+					droppedCounter.Inc(t.options.Name)
+				} else {
+					data = composed
+				}
+			}
+			if data != nil {
+				t.handleFrame(&frame.Frame{Data: data, Time: time.Now()})
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				close(stopped)
+				return
+			}
+		}
+	}()
+	t.stopRecording = func() {
+		close(done)
+		<-stopped
+		for i, source := range sources {
+			source.Remove(clients[i].id, clients[i])
+			releases[i]()
+		}
+	}
+	t.waitForStop = func() error {
+		<-stopped
+		return nil
+	}
+}
+
+// clearLatest discards the latest frame, so that frames of a stopped recording
+// are not returned by the Frame method.
+func (t *registry) clearLatest() {
+	t.lock.Lock()
+	t.latest = nil
+	t.lock.Unlock()
+}
+
 func (t *registry) startRecording() {
+	// Discard frames which arrived after the previous recording was stopped.
+	t.clearLatest()
+	if t.composite != nil {
+		t.startComposite()
+		return
+	}
+	if t.playback != nil {
+		t.startPlayback()
+		return
+	}
+	if t.source != nil {
+		t.startDerived()
+		return
+	}
+	t.showPlaceholder(placeholder.Starting)
 	t.stopRecording, t.waitForStop = startRecording(
-		t.command,
-		t.args,
-		t.clients,
+		recording.Options{
+			Name:         t.options.Name,
+			Command:      t.options.Command,
+			Args:         t.options.Args,
+			Hooks:        t.options.Hooks,
+			StopSignal:   t.options.StopSignal,
+			StopTimeout:  t.options.StopTimeout,
+			StallTimeout: t.options.StallTimeout,
+			Heartbeat:    t.heartbeat,
+			OnCrash:      t.handleCrash,
+		},
+		frame.NewParser(t.options.InputBoundary, t.handleFrame),
 	)
 }
 
@@ -72,11 +557,16 @@ func (t *registry) GenerateID() string {
 // It returns the new number of clients in the Registry.
 func (t *registry) Add(id string, w io.Writer) (num int) {
 	num = t.clients.Add(w)
-	if num == 1 && !t.directStart {
+	if num == 1 && !t.options.DirectStart {
 		// First client added, start the recording.
 		t.startRecording()
 	}
 	log(id, true, num)
+	if num == 1 {
+		event.Emit("clients-start", t.options.Name, map[string]interface{}{
+			"ID": id,
+		})
+	}
 	return
 }
 
@@ -84,27 +574,289 @@ func (t *registry) Add(id string, w io.Writer) (num int) {
 // It returns the new number of clients in the Registry.
 func (t *registry) Remove(id string, w io.Writer) (num int) {
 	num = t.clients.Remove(w)
-	if num == 0 && !t.directStart {
+	if num == 0 && !t.options.DirectStart {
 		// Last client removed, stop the recording.
 		t.stopRecording()
+		t.hidePlaceholder()
+		t.clearLatest()
+		if t.detector != nil {
+			t.detector.Reset()
+		}
 	}
 	log(id, false, num)
+	if num == 0 {
+		event.Emit("clients-end", t.options.Name, map[string]interface{}{
+			"ID": id,
+		})
+	}
 	return
 }
 
+// Status returns the current client count and detected conditions.
+func (t *registry) Status() Status {
+	status := Status{Clients: t.clients.Size()}
+	if t.detector != nil {
+		status.Black = t.detector.Black()
+		status.Frozen = t.detector.Frozen()
+	}
+	return status
+}
+
+// Frame waits until a frame matching the given function is available and
+// returns it. It returns nil if the given context is done before.
+// Only frames of the current recording are returned.
+func (t *registry) Frame(
+	ctx context.Context,
+	match func(f *frame.Frame) bool,
+) *frame.Frame {
+	for {
+		t.lock.Lock()
+		latest := t.latest
+		updated := t.updated
+		t.lock.Unlock()
+		if latest != nil && match(latest) {
+			return latest
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Frames returns the buffered frames received within the given time range.
+// Frames masked with other than the current masks are not returned.
+func (t *registry) Frames(from time.Time, to time.Time) []*frame.Frame {
+	root := t
+	for root.source != nil {
+		root = root.source
+	}
+	if root.options.Masks != nil {
+		root.lock.Lock()
+		root.checkMasks(root.options.Masks.Masks())
+		root.lock.Unlock()
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var frames []*frame.Frame
+	for _, f := range t.buffer {
+		if !f.Time.Before(from) && !f.Time.After(to) {
+			frames = append(frames, f)
+		}
+	}
+	return frames
+}
+
+// Derive returns the Registry for frames of this Registry transformed with the
+// given function and a function to release it again. Derived registries are
+// shared by key and discarded when they are no longer used.
+// Their name is the name of this Registry with the key appended as query.
+func (t *registry) Derive(key string, transform TransformFunc) (
+	reg Registry,
+	release func(),
+) {
+	return t.derive(key, func(derived *registry) {
+		derived.transform = transform
+	})
+}
+
+// Variant returns the Registry for frames of this Registry transformed with the
+// given function like Derive, but without buffering the transformed frames,
+// as exports use the frames of this Registry.
+func (t *registry) Variant(key string, transform TransformFunc) (
+	reg Registry,
+	release func(),
+) {
+	return t.derive(key, func(derived *registry) {
+		derived.transform = transform
+		derived.options.Buffer = 0
+	})
+}
+
+// TimeLapse returns the Registry for the time-lapse playback of this Registry
+// and a function to release it again. It returns a nil Registry if time-lapse
+// sampling is disabled.
+func (t *registry) TimeLapse() (reg Registry, release func()) {
+	if t.sampler == nil {
+		return nil, func() {}
+	}
+	return t.derive("timelapse", func(derived *registry) {
+		// Played back frames are not buffered for exports.
+		derived.options.Buffer = 0
+		// Samples are masked again with the current masks on playback, as the
+		// masks might have changed since they were sampled.
+		derived.options.Masks = t.options.Masks
+		derived.playback = t.sampler
+	})
+}
+
+// derive returns the shared Registry for the given key, which is created with
+// the given setup function if it does not exist yet, and a function to release
+// it again.
+func (t *registry) derive(key string, setup func(derived *registry)) (
+	reg Registry,
+	release func(),
+) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	options := t.options
+	separator := "?"
+	if strings.Contains(options.Name, "?") {
+		separator = "&"
+	}
+	options.Name += separator + key
+	entry, ok := t.derived[key]
+	if !ok {
+		// Frames are already analyzed, validated and masked by the source.
+		options.DirectStart = false
+		options.Analysis = nil
+		options.StallTimeout = 0
+		options.Placeholder = nil
+		options.Validation = ""
+		options.Masks = nil
+		options.TimeLapse = nil
+		derived := New(options).(*registry)
+		derived.source = t
+		setup(derived)
+		entry = &poolEntry{derived, 0}
+		t.derived[key] = entry
+	}
+	entry.refs++
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			t.lock.Lock()
+			entry.refs--
+			if entry.refs == 0 {
+				delete(t.derived, key)
+				droppedCounter.Delete(options.Name)
+			}
+			t.lock.Unlock()
+		})
+	}
+	return entry.registry, release
+}
+
 // New creates a new Registry.
-func New(command string, args []string, directStart bool) Registry {
+func New(options Options) Registry {
 	reg := &registry{
-		command,
-		args,
-		directStart,
+		options,
 		multi.NewMapWriter(),
 		0,
 		nil,
 		nil,
+		nil,
+		&sync.Mutex{},
+		nil,
+		0,
+		make(chan struct{}),
+		make(chan struct{}, 1),
+		&sync.Mutex{},
+		nil,
+		nil,
+		nil,
+		make(map[string]*poolEntry),
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	}
+	if options.Analysis != nil {
+		reg.detector = analysis.New(*options.Analysis, reg.notify)
+		blackGauge.Set(0, options.Name)
+		frozenGauge.Set(0, options.Name)
 	}
-	if directStart {
+	if options.TimeLapse != nil {
+		reg.sampler = timelapse.New(*options.TimeLapse)
+	}
+	if options.DirectStart {
 		reg.startRecording()
 	}
 	return reg
 }
+
+// NewComposite creates a new Registry, which combines the frames of the
+// composite sources. Sources are only used while the Registry has clients.
+func NewComposite(options Options, composite Composite) Registry {
+	options.DirectStart = false
+	reg := New(options).(*registry)
+	reg.composite = &composite
+	return reg
+}
+
+type poolEntry struct {
+	registry Registry
+	refs     int
+}
+
+type pool struct {
+	options Options
+	entries map[string]*poolEntry
+	lock    *sync.Mutex
+}
+
+// Pool is an interface to manage registries for parameterized commands.
+// The Acquire method returns the Registry for the given key, which is created
+// with the given command args if it does not exist yet, and a function to
+// release it again. Registries are discarded when they are no longer used.
+// The Status method returns the status of all current registries by name.
+type Pool interface {
+	Acquire(key string, args []string) (reg Registry, release func())
+	Status() map[string]Status
+}
+
+// Acquire returns the Registry for the given key and a function to release it.
+func (p *pool) Acquire(key string, args []string) (
+	reg Registry,
+	release func(),
+) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry, ok := p.entries[key]
+	if !ok {
+		options := p.options
+		options.Name = p.options.Name + "?" + key
+		options.Args = args
+		entry = &poolEntry{New(options), 0}
+		p.entries[key] = entry
+	}
+	entry.refs++
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			p.lock.Lock()
+			entry.refs--
+			if entry.refs == 0 {
+				delete(p.entries, key)
+				// Discard the metrics of the registry.
+				name := p.options.Name + "?" + key
+				blackGauge.Delete(name)
+				frozenGauge.Delete(name)
+				droppedCounter.Delete(name)
+			}
+			p.lock.Unlock()
+		})
+	}
+	return entry.registry, release
+}
+
+// Status returns the status of all current registries by name.
+func (p *pool) Status() map[string]Status {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	status := make(map[string]Status, len(p.entries))
+	for key, entry := range p.entries {
+		status[p.options.Name+"?"+key] = entry.registry.Status()
+	}
+	return status
+}
+
+// NewPool creates a new Pool, which creates registries with the given options.
+// Registry names are the given name with the key appended as query string.
+// DirectStart is not supported, as registries only exist while used.
+func NewPool(options Options) Pool {
+	options.DirectStart = false
+	return &pool{options, make(map[string]*poolEntry), &sync.Mutex{}}
+}
@@ -4,19 +4,31 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/blueimp/mjpeg-server/internal/analysis"
+	"github.com/blueimp/mjpeg-server/internal/event"
+	"github.com/blueimp/mjpeg-server/internal/frame"
+	"github.com/blueimp/mjpeg-server/internal/mask"
+	"github.com/blueimp/mjpeg-server/internal/placeholder"
 	"github.com/blueimp/mjpeg-server/internal/recording"
+	"github.com/blueimp/mjpeg-server/internal/timelapse"
+	"github.com/blueimp/mjpeg-server/internal/validation"
 )
 
 var started int
 var stopped int
 
-func startRecordingHelper(command string, args []string, w io.Writer) (
+func startRecordingHelper(options recording.Options, w io.Writer) (
 	stop context.CancelFunc,
 	wait recording.WaitFunc,
 ) {
@@ -46,7 +58,7 @@ func outputHelper(fn func()) (stdout []byte, stderr []byte) {
 }
 
 func TestNew(t *testing.T) {
-	reg := New("go", []string{"version"}, false)
+	reg := New(Options{Command: "go", Args: []string{"version"}})
 	if reg == nil {
 		t.Error("Unexpected: nil")
 	}
@@ -57,7 +69,7 @@ func TestNew(t *testing.T) {
 }
 
 func TestGenerateID(t *testing.T) {
-	reg := New("go", []string{"version"}, false)
+	reg := New(Options{Command: "go", Args: []string{"version"}})
 	id := reg.GenerateID()
 	if id != "1" {
 		t.Errorf("Unexpected generated ID: %s. Expected: %s", id, "1")
@@ -76,7 +88,7 @@ func TestAdd(t *testing.T) {
 	started = 0
 	stopped = 0
 	startRecording = startRecordingHelper
-	reg := New("go", []string{"version"}, false)
+	reg := New(Options{Command: "go", Args: []string{"version"}})
 	if started != 0 {
 		t.Errorf("Unexpected started recordings: %d. Expected: %d", started, 0)
 	}
@@ -92,7 +104,7 @@ func TestAdd(t *testing.T) {
 		t.Errorf("Unexpected stderr: %s", stderr)
 	}
 	var entry logEntry
-	json.Unmarshal(stdout, &entry)
+	json.NewDecoder(bytes.NewReader(stdout)).Decode(&entry)
 	if entry.ID != "1" {
 		t.Errorf("Unexpected 'ID' log: %s. Expected: %s", entry.ID, "1")
 	}
@@ -126,7 +138,7 @@ func TestAdd(t *testing.T) {
 		t.Errorf("Unexpected stderr: %s", stderr)
 	}
 	entry = logEntry{}
-	json.Unmarshal(stdout, &entry)
+	json.NewDecoder(bytes.NewReader(stdout)).Decode(&entry)
 	if entry.ID != "2" {
 		t.Errorf("Unexpected 'ID' log: %s. Expected: %s", entry.ID, "2")
 	}
@@ -150,7 +162,7 @@ func TestRemove(t *testing.T) {
 	started = 0
 	stopped = 0
 	startRecording = startRecordingHelper
-	reg := New("go", []string{"version"}, false)
+	reg := New(Options{Command: "go", Args: []string{"version"}})
 	var (
 		buffer1 bytes.Buffer
 		buffer2 bytes.Buffer
@@ -174,7 +186,7 @@ func TestRemove(t *testing.T) {
 		t.Errorf("Unexpected stderr: %s", stderr)
 	}
 	var entry logEntry
-	json.Unmarshal(stdout, &entry)
+	json.NewDecoder(bytes.NewReader(stdout)).Decode(&entry)
 	if entry.ID != "2" {
 		t.Errorf("Unexpected 'ID' log: %s. Expected: %s", entry.ID, "2")
 	}
@@ -208,7 +220,7 @@ func TestRemove(t *testing.T) {
 		t.Errorf("Unexpected stderr: %s", stderr)
 	}
 	entry = logEntry{}
-	json.Unmarshal(stdout, &entry)
+	json.NewDecoder(bytes.NewReader(stdout)).Decode(&entry)
 	if entry.ID != "1" {
 		t.Errorf("Unexpected 'ID' log: %s. Expected: %s", entry.ID, "1")
 	}
@@ -232,7 +244,11 @@ func TestNewWithDirectStart(t *testing.T) {
 	started = 0
 	stopped = 0
 	startRecording = startRecordingHelper
-	reg := New("go", []string{"version"}, true)
+	reg := New(Options{
+		Command:     "go",
+		Args:        []string{"version"},
+		DirectStart: true,
+	})
 	if started != 1 {
 		t.Errorf("Unexpected started recordings: %d. Expected: %d", started, 1)
 	}
@@ -250,3 +266,730 @@ func TestNewWithDirectStart(t *testing.T) {
 		t.Errorf("Unexpected stopped recordings: %d. Expected: %d", stopped, 0)
 	}
 }
+
+func TestStatus(t *testing.T) {
+	startRecording = startRecordingHelper
+	reg := New(Options{
+		Name:     "/status",
+		Command:  "go",
+		Args:     []string{"version"},
+		Analysis: &analysis.Options{BlackTimeout: time.Second},
+	})
+	outputHelper(func() {
+		reg.Add("1", &bytes.Buffer{})
+	})
+	status := reg.Status()
+	if status.Clients != 1 {
+		t.Errorf("Unexpected clients: %d. Expected: %d", status.Clients, 1)
+	}
+	if status.Black || status.Frozen {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	since := time.Now()
+	stdout, _ := outputHelper(func() {
+		reg.(*registry).notify(analysis.Black, true, since)
+	})
+	var entry event.Event
+	json.NewDecoder(bytes.NewReader(stdout)).Decode(&entry)
+	if entry.Event != "black-start" {
+		t.Errorf(
+			"Unexpected 'Event' log: %s. Expected: %s",
+			entry.Event,
+			"black-start",
+		)
+	}
+	if entry.Stream != "/status" {
+		t.Errorf(
+			"Unexpected 'Stream' log: %s. Expected: %s",
+			entry.Stream,
+			"/status",
+		)
+	}
+	value := blackGauge.Value("/status")
+	if value != 1 {
+		t.Errorf("Unexpected black gauge: %g. Expected: %g", value, 1.0)
+	}
+	outputHelper(func() {
+		reg.(*registry).notify(analysis.Black, false, since)
+	})
+	value = blackGauge.Value("/status")
+	if value != 0 {
+		t.Errorf("Unexpected black gauge: %g. Expected: %g", value, 0.0)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("--ffmpeg\r\n\r\nbanana\r\n"))
+			w.Write([]byte("--ffmpeg\r\n\r\napple\r\n--ffmpeg--\r\n"))
+		}()
+		return func() {}, func() error { return nil }
+	}
+	reg := New(Options{Boundary: "ffmpeg"})
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg.Add("1", &buffer)
+	})
+	f := reg.Frame(context.Background(), func(f *frame.Frame) bool {
+		return f.Sequence > 1
+	})
+	if f == nil {
+		t.Fatal("Unexpected: nil")
+	}
+	if string(f.Data) != "apple" {
+		t.Errorf("Unexpected frame data: %s. Expected: %s", f.Data, "apple")
+	}
+	if f.Sequence != 2 {
+		t.Errorf("Unexpected frame sequence: %d. Expected: %d", f.Sequence, 2)
+	}
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		50*time.Millisecond,
+	)
+	defer cancel()
+	f = reg.Frame(ctx, func(f *frame.Frame) bool {
+		return f.Sequence > 2
+	})
+	if f != nil {
+		t.Errorf("Unexpected frame: %d", f.Sequence)
+	}
+}
+
+func TestFrameAfterStop(t *testing.T) {
+	var w io.Writer
+	startRecording = func(options recording.Options, writer io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		w = writer
+		return func() {}, func() error { return nil }
+	}
+	reg := New(Options{Boundary: "ffmpeg"})
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg.Add("1", &buffer)
+	})
+	w.Write([]byte("--ffmpeg\r\n\r\nbanana\r\n--ffmpeg--\r\n"))
+	all := func(f *frame.Frame) bool { return true }
+	if f := reg.Frame(context.Background(), all); f == nil {
+		t.Fatal("Unexpected: nil")
+	}
+	outputHelper(func() {
+		reg.Remove("1", &buffer)
+		reg.Add("2", &buffer)
+	})
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		50*time.Millisecond,
+	)
+	defer cancel()
+	if f := reg.Frame(ctx, all); f != nil {
+		t.Errorf("Unexpected frame of stopped recording: %s", f.Data)
+	}
+	w.Write([]byte("--ffmpeg\r\n\r\napple\r\n--ffmpeg--\r\n"))
+	f := reg.Frame(context.Background(), all)
+	if f == nil {
+		t.Fatal("Unexpected: nil")
+	}
+	if string(f.Data) != "apple" {
+		t.Errorf("Unexpected frame data: %s. Expected: %s", f.Data, "apple")
+	}
+	if f.Sequence != 2 {
+		t.Errorf("Unexpected frame sequence: %d. Expected: %d", f.Sequence, 2)
+	}
+}
+
+func TestFrameEncoding(t *testing.T) {
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\nbanana\r\n"))
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	reg := New(Options{Boundary: "ffmpeg"})
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg.Add("1", &buffer)
+	})
+	f := reg.Frame(context.Background(), func(f *frame.Frame) bool {
+		return true
+	})
+	expectedOutput := f.Encode("ffmpeg")
+	if !bytes.Equal(buffer.Bytes(), expectedOutput) {
+		t.Errorf(
+			"Unexpected output: %q. Expected: %q",
+			buffer.Bytes(),
+			expectedOutput,
+		)
+	}
+	if !bytes.Contains(expectedOutput, []byte("\r\nX-Sequence: 1\r\n")) {
+		t.Errorf("Unexpected output: %q", expectedOutput)
+	}
+}
+
+func TestRecordingOptions(t *testing.T) {
+	var options recording.Options
+	startRecording = func(o recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		options = o
+		return func() {}, func() error { return nil }
+	}
+	hooks := recording.Hooks{PreStart: "/bin/true", Timeout: time.Second}
+	outputHelper(func() {
+		New(Options{
+			Name:        "/hooks",
+			Command:     "go",
+			Args:        []string{"version"},
+			DirectStart: true,
+			Hooks:       hooks,
+			StopSignal:  "SIGTERM",
+			StopTimeout: time.Second,
+		})
+	})
+	if options.Name != "/hooks" || options.Command != "go" {
+		t.Errorf("Unexpected recording options: %+v", options)
+	}
+	if options.StopSignal != "SIGTERM" || options.StopTimeout != time.Second {
+		t.Errorf("Unexpected stop options: %+v", options)
+	}
+	if options.Hooks != hooks {
+		t.Errorf("Unexpected hooks: %+v. Expected: %+v", options.Hooks, hooks)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var options recording.Options
+	startRecording = func(o recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		options = o
+		w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\nbanana\r\n"))
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	outputHelper(func() {
+		New(Options{DirectStart: true, StallTimeout: time.Second})
+	})
+	if options.StallTimeout != time.Second {
+		t.Errorf(
+			"Unexpected stall timeout: %s. Expected: %s",
+			options.StallTimeout,
+			time.Second,
+		)
+	}
+	select {
+	case <-options.Heartbeat:
+	default:
+		t.Error("Unexpected: no heartbeat for complete frame")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, 16, 16)), nil)
+	valid := buffer.Bytes()
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		for _, data := range [][]byte{
+			valid[:len(valid)/2],
+			valid,
+			[]byte("banana"),
+		} {
+			w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+			w.Write(data)
+			w.Write([]byte("\r\n"))
+		}
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	reg := New(Options{
+		Name:        "/validation",
+		DirectStart: true,
+		Validation:  validation.Markers,
+	})
+	f := reg.Frame(context.Background(), func(f *frame.Frame) bool {
+		return true
+	})
+	if !bytes.Equal(f.Data, valid) || f.Sequence != 1 {
+		t.Errorf("Unexpected frame: %d %q", f.Sequence, f.Data)
+	}
+	if value := droppedCounter.Value("/validation"); value != 2 {
+		t.Errorf("Unexpected dropped frames: %v. Expected: %v", value, 2)
+	}
+}
+
+func TestMasks(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, nil)
+	valid := buffer.Bytes()
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		for _, data := range [][]byte{valid, []byte("banana")} {
+			w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+			w.Write(data)
+			w.Write([]byte("\r\n"))
+		}
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	masks, _ := mask.Parse([]string{"0,0,100%,100%"})
+	reg := New(Options{
+		Name:        "/masks",
+		DirectStart: true,
+		Masks:       mask.NewStore(masks),
+	})
+	f := reg.Frame(context.Background(), func(f *frame.Frame) bool {
+		return true
+	})
+	if f.Sequence != 1 {
+		t.Errorf("Unexpected frame sequence: %d. Expected: %d", f.Sequence, 1)
+	}
+	masked, err := jpeg.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if r, _, _, _ := masked.At(16, 16).RGBA(); r > 0x1000 {
+		t.Errorf("Unexpected unmasked pixel: %v", masked.At(16, 16))
+	}
+	if value := droppedCounter.Value("/masks"); value != 1 {
+		t.Errorf("Unexpected dropped frames: %v. Expected: %v", value, 1)
+	}
+}
+
+func TestFramesWithChangedMasks(t *testing.T) {
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, 32, 32)), nil)
+	valid := buffer.Bytes()
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		for i := 0; i < 2; i++ {
+			w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+			w.Write(valid)
+			w.Write([]byte("\r\n"))
+		}
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	store := mask.NewStore(nil)
+	from := time.Now()
+	reg := New(Options{
+		Name:        "/",
+		DirectStart: true,
+		Masks:       store,
+		Buffer:      time.Minute,
+		TimeLapse:   &timelapse.Options{},
+	})
+	frames := reg.Frames(from, time.Now())
+	if len(frames) != 2 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+	}
+	derived, release := reg.Derive("crop=0,0,10,10", func(data []byte) (
+		[]byte,
+		error,
+	) {
+		return data, nil
+	})
+	defer release()
+	derived.(*registry).buffer = frames
+	playback, releasePlayback := reg.TimeLapse()
+	defer releasePlayback()
+	if playback.(*registry).options.Masks != store {
+		t.Error("Unexpected: time-lapse playback without masks")
+	}
+	// Setting the same masks again keeps the buffered frames.
+	store.Set(mask.Masks{})
+	if frames = reg.Frames(from, time.Now()); len(frames) != 2 {
+		t.Errorf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+	}
+	masks, _ := mask.Parse([]string{"0,0,100%,100%"})
+	store.Set(masks)
+	if frames = derived.Frames(from, time.Now()); len(frames) != 0 {
+		t.Errorf("Unexpected number of frames: %d. Expected: %d", len(frames), 0)
+	}
+	if frames = reg.Frames(from, time.Now()); len(frames) != 0 {
+		t.Errorf("Unexpected number of frames: %d. Expected: %d", len(frames), 0)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+		w.Write([]byte("banana\r\n--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	source := New(Options{Name: "/?size=hd", Boundary: "ffmpeg"})
+	transform := func(data []byte) ([]byte, error) {
+		return append([]byte("apple "), data...), nil
+	}
+	reg, release := source.Derive("crop=0,0,10,10", transform)
+	shared, releaseShared := source.Derive("crop=0,0,10,10", transform)
+	if shared != reg {
+		t.Error("Unexpected: derived registry not shared")
+	}
+	if name := reg.(*registry).options.Name; name != "/?size=hd&crop=0,0,10,10" {
+		t.Errorf("Unexpected name: %s", name)
+	}
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg.Add("1", &buffer)
+	})
+	if num := source.Status().Clients; num != 1 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	f := reg.Frame(ctx, func(f *frame.Frame) bool {
+		return true
+	})
+	if f == nil || string(f.Data) != "apple banana" {
+		t.Errorf("Unexpected frame: %v", f)
+	}
+	outputHelper(func() {
+		reg.Remove("1", &buffer)
+	})
+	if num := source.Status().Clients; num != 0 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 0)
+	}
+	release()
+	releaseShared()
+	other, releaseOther := source.Derive("crop=0,0,10,10", transform)
+	defer releaseOther()
+	if other == reg {
+		t.Error("Unexpected: released registry not discarded")
+	}
+}
+
+func TestVariant(t *testing.T) {
+	source := New(Options{Name: "/", Buffer: time.Minute})
+	transform := func(data []byte) ([]byte, error) {
+		return data, nil
+	}
+	reg, release := source.Variant("quality=1", transform)
+	defer release()
+	if name := reg.(*registry).options.Name; name != "/?quality=1" {
+		t.Errorf("Unexpected name: %s", name)
+	}
+	if buffer := reg.(*registry).options.Buffer; buffer != 0 {
+		t.Errorf("Unexpected variant buffer: %s. Expected: %d", buffer, 0)
+	}
+	derived, releaseDerived := source.Derive("crop=0,0,10,10", transform)
+	defer releaseDerived()
+	if buffer := derived.(*registry).options.Buffer; buffer != time.Minute {
+		t.Errorf(
+			"Unexpected derived buffer: %s. Expected: %s",
+			buffer,
+			time.Minute,
+		)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		for _, data := range []string{"banana", "apple", "orange"} {
+			w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+			w.Write([]byte(data + "\r\n"))
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	from := time.Now()
+	reg := New(Options{DirectStart: true, Buffer: 30 * time.Millisecond})
+	to := time.Now()
+	frames := reg.Frames(from, to)
+	if len(frames) != 2 {
+		t.Fatalf("Unexpected number of frames: %d. Expected: %d", len(frames), 2)
+	}
+	if string(frames[0].Data) != "apple" || string(frames[1].Data) != "orange" {
+		t.Errorf("Unexpected frames: %q, %q", frames[0].Data, frames[1].Data)
+	}
+	if frames = reg.Frames(from, frames[0].Time); len(frames) != 1 {
+		t.Errorf("Unexpected number of frames: %d. Expected: %d", len(frames), 1)
+	}
+	reg = New(Options{DirectStart: true})
+	if frames = reg.Frames(from, time.Now()); len(frames) != 0 {
+		t.Errorf("Unexpected frames without buffer: %d", len(frames))
+	}
+}
+
+func TestTimeLapse(t *testing.T) {
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		for _, data := range []string{"banana", "apple", "orange"} {
+			w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+			w.Write([]byte(data + "\r\n"))
+		}
+		w.Write([]byte("--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	if reg, _ := New(Options{}).TimeLapse(); reg != nil {
+		t.Error("Unexpected time-lapse registry without sampling")
+	}
+	source := New(Options{
+		Name:        "/",
+		DirectStart: true,
+		Boundary:    "ffmpeg",
+		TimeLapse:   &timelapse.Options{FPS: 100},
+	})
+	reg, release := source.TimeLapse()
+	defer release()
+	if name := reg.(*registry).options.Name; name != "/?timelapse" {
+		t.Errorf("Unexpected name: %s", name)
+	}
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg.Add("1", &buffer)
+	})
+	if num := source.Status().Clients; num != 1 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// The fourth played back frame starts the second loop.
+	f := reg.Frame(ctx, func(f *frame.Frame) bool {
+		return f.Sequence >= 4
+	})
+	if f == nil || string(f.Data) != "banana" {
+		t.Errorf("Unexpected frame: %v", f)
+	}
+	outputHelper(func() {
+		reg.Remove("1", &buffer)
+	})
+	if num := source.Status().Clients; num != 0 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 0)
+	}
+}
+
+func TestComposite(t *testing.T) {
+	startRecording = func(options recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n"))
+		w.Write([]byte(options.Name + "\r\n--ffmpeg--\r\n"))
+		return func() {}, func() error { return nil }
+	}
+	var sources []Registry
+	var released int32
+	composite := Composite{Interval: 10 * time.Millisecond}
+	for _, name := range []string{"banana", "apple"} {
+		source := New(Options{Name: name, Boundary: "ffmpeg"})
+		sources = append(sources, source)
+		composite.Sources = append(
+			composite.Sources,
+			func() (Registry, func()) {
+				return source, func() { atomic.AddInt32(&released, 1) }
+			},
+		)
+	}
+	composite.Compose = func(frames []*frame.Frame) ([]byte, error) {
+		var names []string
+		for _, f := range frames {
+			if f != nil {
+				names = append(names, string(f.Data))
+			}
+		}
+		return []byte(strings.Join(names, ",")), nil
+	}
+	reg := NewComposite(Options{Name: "/grid", Boundary: "ffmpeg"}, composite)
+	if num := sources[0].Status().Clients; num != 0 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 0)
+	}
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg.Add("1", &buffer)
+	})
+	for _, source := range sources {
+		if num := source.Status().Clients; num != 1 {
+			t.Errorf("Unexpected source clients: %d. Expected: %d", num, 1)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	f := reg.Frame(ctx, func(f *frame.Frame) bool {
+		return string(f.Data) == "banana,apple"
+	})
+	if f == nil {
+		t.Error("Unexpected: composed frame not found")
+	}
+	outputHelper(func() {
+		reg.Remove("1", &buffer)
+	})
+	for _, source := range sources {
+		if num := source.Status().Clients; num != 0 {
+			t.Errorf("Unexpected source clients: %d. Expected: %d", num, 0)
+		}
+	}
+	if n := atomic.LoadInt32(&released); n != 2 {
+		t.Errorf("Unexpected released sources: %d. Expected: %d", n, 2)
+	}
+}
+
+type placeholderHelper struct{}
+
+func (p placeholderHelper) JPEG(status string) []byte {
+	return []byte(status)
+}
+
+func (p placeholderHelper) Interval() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func TestPlaceholder(t *testing.T) {
+	var options recording.Options
+	var w io.Writer
+	startRecording = func(o recording.Options, writer io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		options = o
+		w = writer
+		return func() {}, func() error { return nil }
+	}
+	reg := New(Options{Boundary: "ffmpeg", Placeholder: placeholderHelper{}})
+	frames := make(chan *frame.Frame, 100)
+	client := frame.NewParser("ffmpeg", func(f *frame.Frame) {
+		frames <- f
+	})
+	// waitHelper returns the first frame with the given placeholder status.
+	waitHelper := func(status string) *frame.Frame {
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case f := <-frames:
+				if f.Header.Get("X-Placeholder") == status {
+					return f
+				}
+			case <-timeout:
+				t.Fatalf("Unexpected: no frame with status: %s", status)
+			}
+		}
+	}
+	outputHelper(func() {
+		reg.Add("1", client)
+	})
+	f := waitHelper(placeholder.Starting)
+	if string(f.Data) != placeholder.Starting {
+		t.Errorf(
+			"Unexpected frame data: %s. Expected: %s",
+			f.Data,
+			placeholder.Starting,
+		)
+	}
+	if sequence := f.Header.Get("X-Sequence"); sequence != "" {
+		t.Errorf("Unexpected placeholder sequence: %s", sequence)
+	}
+	options.OnCrash(errors.New("banana"), true)
+	waitHelper(placeholder.Restarting)
+	w.Write([]byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\nbanana\r\n"))
+	w.Write([]byte("--ffmpeg--\r\n"))
+	f = waitHelper("")
+	if string(f.Data) != "banana" {
+		t.Errorf("Unexpected frame data: %s. Expected: %s", f.Data, "banana")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(frames) != 0 {
+		t.Errorf("Unexpected placeholder frames after live frame: %d", len(frames))
+	}
+	options.OnCrash(errors.New("banana"), false)
+	waitHelper(placeholder.Failed)
+	outputHelper(func() {
+		reg.Remove("1", client)
+	})
+	time.Sleep(50 * time.Millisecond)
+	for len(frames) > 0 {
+		<-frames
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(frames) != 0 {
+		t.Errorf("Unexpected placeholder frames after stop: %d", len(frames))
+	}
+}
+
+func TestPool(t *testing.T) {
+	var started []recording.Options
+	stopped = 0
+	startRecording = func(o recording.Options, w io.Writer) (
+		stop context.CancelFunc,
+		wait recording.WaitFunc,
+	) {
+		started = append(started, o)
+		return func() { stopped++ }, func() error { return nil }
+	}
+	p := NewPool(Options{Name: "/", Command: "go", DirectStart: true})
+	reg1, release1 := p.Acquire("display=%3A1", []string{":1"})
+	reg2, release2 := p.Acquire("display=%3A2", []string{":2"})
+	reg3, release3 := p.Acquire("display=%3A1", []string{":1"})
+	if reg1 != reg3 {
+		t.Error("Unexpected: different registries for the same key")
+	}
+	if reg1 == reg2 {
+		t.Error("Unexpected: same registry for different keys")
+	}
+	if len(started) != 0 {
+		t.Errorf("Unexpected started recordings: %d", len(started))
+	}
+	var buffer bytes.Buffer
+	outputHelper(func() {
+		reg2.Add("1", &buffer)
+	})
+	if len(started) != 1 {
+		t.Fatalf("Unexpected started recordings: %d", len(started))
+	}
+	if started[0].Name != "/?display=%3A2" || started[0].Args[0] != ":2" {
+		t.Errorf("Unexpected recording options: %+v", started[0])
+	}
+	status := p.Status()
+	if len(status) != 2 || status["/?display=%3A2"].Clients != 1 {
+		t.Errorf("Unexpected status: %v", status)
+	}
+	outputHelper(func() {
+		reg2.Remove("1", &buffer)
+	})
+	if stopped != 1 {
+		t.Errorf("Unexpected stopped recordings: %d. Expected: %d", stopped, 1)
+	}
+	release2()
+	release1()
+	if len(p.Status()) != 1 {
+		t.Errorf("Unexpected status after release: %v", p.Status())
+	}
+	release3()
+	release3()
+	if len(p.Status()) != 0 {
+		t.Errorf("Unexpected status after release: %v", p.Status())
+	}
+	reg4, release4 := p.Acquire("display=%3A1", []string{":1"})
+	defer release4()
+	if reg4 == reg1 {
+		t.Error("Unexpected: released registry reused")
+	}
+}
@@ -1,42 +1,288 @@
 /*
-Package request provides a simple JSON logger for http.Request objects.
+Package request provides a simple JSON logger for http.Request objects and
+resolves the client IP and scheme of requests passed on by trusted proxies.
 */
 package request
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/jwt"
+	"github.com/blueimp/mjpeg-server/internal/signature"
 )
 
 type logEntry struct {
 	ID             string
 	Time           time.Time
 	RemoteIP       string
+	Scheme         string
+	Subject        string
 	Method         string
 	Host           string
 	RequestURI     string
 	Referrer       string
 	UserAgent      string
+	Forwarded      string
 	ForwardedFor   string
 	ForwardedHost  string
 	ForwardedProto string
 }
 
+// hop is a single proxy hop with the client address and protocol it received.
+type hop struct {
+	client string
+	proto  string
+}
+
+// subjectKey is the request context key for the authenticated subject.
+type subjectKey struct{}
+
+// redactedValue replaces the values of credential query parameters in the log.
+const redactedValue = "REDACTED"
+
+// Query parameters with credentials, which are redacted in the log.
+var secretParams = map[string]bool{
+	jwt.QueryParam:           true,
+	signature.SignatureParam: true,
+}
+
+// TrustUnix is the trusted proxy value for peers of Unix domain sockets.
+const TrustUnix = "unix"
+
+var (
+	trustedProxies []*net.IPNet
+	trustUnix      bool
+	lock           = &sync.RWMutex{}
+)
+
+// SetTrustedProxies sets the proxy networks in CIDR notation, whose forwarding
+// headers are used to resolve the client IP and scheme.
+// Single IP addresses are also accepted, as well as "unix" to trust peers
+// connected via Unix domain socket.
+func SetTrustedProxies(cidrs []string) error {
+	var networks []*net.IPNet
+	unix := false
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if cidr == TrustUnix {
+			unix = true
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy: %s", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy: %s", cidr)
+		}
+		networks = append(networks, network)
+	}
+	lock.Lock()
+	trustedProxies = networks
+	trustUnix = unix
+	lock.Unlock()
+	return nil
+}
+
+func trusted(ip string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	if ip == "" {
+		// Unix domain socket peers have no IP address.
+		return trustUnix
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNode returns the IP of a Forwarded node identifier, which can be quoted
+// and include a port, e.g. "[2001:db8:cafe::17]:4711".
+func parseNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if strings.HasPrefix(node, "[") {
+		end := strings.Index(node, "]")
+		if end == -1 {
+			return ""
+		}
+		node = node[1:end]
+	} else if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	if net.ParseIP(node) == nil {
+		// Obfuscated or unknown identifier.
+		return ""
+	}
+	return node
+}
+
+// parseForwarded parses the hops of the Forwarded header.
+// See also: https://tools.ietf.org/html/rfc7239#section-4
+func parseForwarded(header []string) (hops []hop) {
+	for _, value := range header {
+		for _, element := range strings.Split(value, ",") {
+			var h hop
+			for _, pair := range strings.Split(element, ";") {
+				index := strings.Index(pair, "=")
+				if index == -1 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(pair[:index]))
+				value := strings.TrimSpace(pair[index+1:])
+				switch key {
+				case "for":
+					h.client = parseNode(value)
+				case "proto":
+					h.proto = strings.ToLower(strings.Trim(value, `"`))
+				}
+			}
+			hops = append(hops, h)
+		}
+	}
+	return
+}
+
+// parseForwardedFor parses the hops of the X-Forwarded-For header, using the
+// last X-Forwarded-Proto value as protocol of the last hop.
+func parseForwardedFor(header []string, proto string) (hops []hop) {
+	for _, value := range header {
+		for _, client := range strings.Split(value, ",") {
+			hops = append(hops, hop{client: parseNode(strings.TrimSpace(client))})
+		}
+	}
+	if len(hops) > 0 && proto != "" {
+		protos := strings.Split(proto, ",")
+		hops[len(hops)-1].proto = strings.ToLower(
+			strings.TrimSpace(protos[len(protos)-1]),
+		)
+	}
+	return
+}
+
+// Client returns the IP and scheme of the client which sent the request.
+// Forwarding headers are processed from the closest proxy backwards, as long
+// as the proxies are trusted. The standard Forwarded header takes precedence
+// over the X-Forwarded-For and X-Forwarded-Proto headers.
+func Client(req *http.Request) (ip string, scheme string) {
+	ip, _, _ = net.SplitHostPort(req.RemoteAddr)
+	scheme = "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if !trusted(ip) {
+		return
+	}
+	var hops []hop
+	if forwarded := req.Header["Forwarded"]; len(forwarded) > 0 {
+		hops = parseForwarded(forwarded)
+	} else {
+		hops = parseForwardedFor(
+			req.Header["X-Forwarded-For"],
+			req.Header.Get("X-Forwarded-Proto"),
+		)
+	}
+	for i := len(hops) - 1; i >= 0 && trusted(ip); i-- {
+		if hops[i].client == "" {
+			break
+		}
+		ip = hops[i].client
+		if hops[i].proto == "http" || hops[i].proto == "https" {
+			scheme = hops[i].proto
+		}
+	}
+	return
+}
+
+// ClientIP returns the IP of the client which sent the request.
+func ClientIP(req *http.Request) string {
+	ip, _ := Client(req)
+	return ip
+}
+
+// WithSubject returns a copy of the request with the given authenticated
+// subject, which is logged as Subject.
+func WithSubject(req *http.Request, subject string) *http.Request {
+	return req.WithContext(
+		context.WithValue(req.Context(), subjectKey{}, subject),
+	)
+}
+
+// Subject returns the authenticated subject of the given request, which is
+// empty for anonymous requests.
+func Subject(req *http.Request) string {
+	subject, _ := req.Context().Value(subjectKey{}).(string)
+	return subject
+}
+
+// redactURI returns the request URI of the given URL with the values of
+// credential query parameters redacted, keeping the order of the parameters.
+func redactURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		key := param
+		if index := strings.Index(param, "="); index >= 0 {
+			key = param[:index]
+		}
+		if name, err := url.QueryUnescape(key); err == nil && secretParams[name] {
+			params[i] = key + "=" + redactedValue
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return redacted.RequestURI()
+}
+
 // Log prints details for the given request object as JSON to STDOUT.
+// The RemoteIP and Scheme are resolved via trusted proxies.
+// Bearer tokens and URL signatures in the query are redacted.
 func Log(req *http.Request, id string) {
-	ip, _, _ := net.SplitHostPort(req.RemoteAddr)
+	ip, scheme := Client(req)
 	entry := &logEntry{
 		ID:             id,
 		Time:           time.Now().UTC(),
 		RemoteIP:       ip,
+		Scheme:         scheme,
+		Subject:        Subject(req),
 		Method:         req.Method,
 		Host:           req.Host,
-		RequestURI:     req.URL.RequestURI(),
+		RequestURI:     redactURI(req.URL),
 		Referrer:       req.Header.Get("Referer"),
 		UserAgent:      req.Header.Get("User-Agent"),
+		Forwarded:      req.Header.Get("Forwarded"),
 		ForwardedFor:   req.Header.Get("X-Forwarded-For"),
 		ForwardedHost:  req.Header.Get("X-Forwarded-Host"),
 		ForwardedProto: req.Header.Get("X-Forwarded-Proto"),
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -117,3 +118,169 @@ func TestLog(t *testing.T) {
 		)
 	}
 }
+
+func TestClient(t *testing.T) {
+	err := SetTrustedProxies([]string{"192.0.2.0/24", "10.0.0.1", "unix"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer SetTrustedProxies(nil)
+	tests := []struct {
+		remoteAddr string
+		header     map[string]string
+		ip         string
+		scheme     string
+	}{
+		{"198.51.100.1:1234", nil, "198.51.100.1", "http"},
+		{
+			"198.51.100.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			"198.51.100.1",
+			"http",
+		},
+		{
+			"192.0.2.1:1234",
+			map[string]string{
+				"X-Forwarded-For":   "203.0.113.1, 10.0.0.1",
+				"X-Forwarded-Proto": "https",
+			},
+			"203.0.113.1",
+			"https",
+		},
+		{
+			"192.0.2.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.2, 203.0.113.1"},
+			"203.0.113.1",
+			"http",
+		},
+		{
+			"192.0.2.1:1234",
+			map[string]string{
+				"Forwarded": `for=203.0.113.1;proto=https, ` +
+					`for="[2001:db8:cafe::17]:4711";proto=http, for=10.0.0.1`,
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			"2001:db8:cafe::17",
+			"http",
+		},
+		{
+			"192.0.2.1:1234",
+			map[string]string{"Forwarded": `for=_hidden;proto=https`},
+			"192.0.2.1",
+			"http",
+		},
+		{
+			"@",
+			map[string]string{"Forwarded": `For="203.0.113.1:80";Proto=HTTPS`},
+			"203.0.113.1",
+			"https",
+		},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://localhost:9000/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		ip, scheme := Client(req)
+		if ip != test.ip {
+			t.Errorf("Unexpected IP: %s. Expected: %s", ip, test.ip)
+		}
+		if scheme != test.scheme {
+			t.Errorf("Unexpected scheme: %s. Expected: %s", scheme, test.scheme)
+		}
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	for _, cidr := range []string{"banana", "192.0.2.0/33"} {
+		err := SetTrustedProxies([]string{cidr})
+		if err == nil {
+			t.Errorf("Unexpected nil error for trusted proxy: %s", cidr)
+		}
+	}
+}
+
+func TestLogWithTrustedProxy(t *testing.T) {
+	SetTrustedProxies([]string{"192.0.2.1"})
+	defer SetTrustedProxies(nil)
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/mjpeg",
+		nil,
+	)
+	req.Header.Set("Forwarded", "for=203.0.113.1;proto=https")
+	stdout, _ := outputHelper(func() {
+		Log(req, "1")
+	})
+	var entry logEntry
+	json.Unmarshal(stdout, &entry)
+	if entry.RemoteIP != "203.0.113.1" {
+		t.Errorf(
+			"Unexpected 'RemoteIP' log: %s. Expected: %s",
+			entry.RemoteIP,
+			"203.0.113.1",
+		)
+	}
+	if entry.Scheme != "https" {
+		t.Errorf("Unexpected 'Scheme' log: %s. Expected: %s", entry.Scheme, "https")
+	}
+	if entry.Forwarded != "for=203.0.113.1;proto=https" {
+		t.Errorf(
+			"Unexpected 'Forwarded' log: %s. Expected: %s",
+			entry.Forwarded,
+			"for=203.0.113.1;proto=https",
+		)
+	}
+}
+
+func TestLogWithSubject(t *testing.T) {
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/mjpeg",
+		nil,
+	)
+	if subject := Subject(req); subject != "" {
+		t.Errorf("Unexpected subject: %s. Expected: %s", subject, "")
+	}
+	stdout, _ := outputHelper(func() {
+		Log(WithSubject(req, "dashboard"), "1")
+	})
+	var entry logEntry
+	json.Unmarshal(stdout, &entry)
+	if entry.Subject != "dashboard" {
+		t.Errorf(
+			"Unexpected 'Subject' log: %s. Expected: %s",
+			entry.Subject,
+			"dashboard",
+		)
+	}
+}
+
+func TestLogWithCredentials(t *testing.T) {
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/mjpeg?access_token=banana&display=%3A1"+
+			"&expires=1588334400&signature=apple&access%5Ftoken=orange",
+		nil,
+	)
+	stdout, _ := outputHelper(func() {
+		Log(req, "1")
+	})
+	for _, secret := range []string{"banana", "apple", "orange"} {
+		if strings.Contains(string(stdout), secret) {
+			t.Errorf("Unexpected credential in log: %s", secret)
+		}
+	}
+	var entry logEntry
+	json.Unmarshal(stdout, &entry)
+	expected := "/mjpeg?access_token=REDACTED&display=%3A1" +
+		"&expires=1588334400&signature=REDACTED&access%5Ftoken=REDACTED"
+	if entry.RequestURI != expected {
+		t.Errorf(
+			"Unexpected 'RequestURI' log: %s. Expected: %s",
+			entry.RequestURI,
+			expected,
+		)
+	}
+}
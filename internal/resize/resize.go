@@ -0,0 +1,55 @@
+/*
+Package resize implements scaling images with a box filter.
+*/
+package resize
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Box returns the image scaled to the given size, averaging the source pixels
+// of each target pixel. Images are enlarged by repeating the source pixels.
+// The image is returned as RGBA copy if it already has the given size.
+func Box(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return src
+	}
+	if width < 1 || height < 1 || bounds.Empty() {
+		return image.NewRGBA(image.Rectangle{})
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * bounds.Dy() / height
+		y1 := (y + 1) * bounds.Dy() / height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := x * bounds.Dx() / width
+			x1 := (x + 1) * bounds.Dx() / width
+			if x1 == x0 {
+				x1++
+			}
+			var sum [4]int
+			n := 0
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					for c := range sum {
+						sum[c] += int(src.Pix[i+c])
+					}
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
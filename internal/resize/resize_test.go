@@ -0,0 +1,46 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBox(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 60), 0, 0, 255})
+		}
+	}
+	scaled := Box(img, 2, 1)
+	if scaled.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("Unexpected bounds: %v", scaled.Bounds())
+	}
+	// Averages of the red values 0 and 60 and of 120 and 180.
+	for x, expected := range []uint8{30, 150} {
+		if value := scaled.RGBAAt(x, 0).R; value != expected {
+			t.Errorf(
+				"Unexpected red value at x=%d: %d. Expected: %d",
+				x,
+				value,
+				expected,
+			)
+		}
+	}
+	enlarged := Box(img, 8, 4)
+	if value := enlarged.RGBAAt(7, 3).R; value != 180 {
+		t.Errorf("Unexpected red value: %d. Expected: %d", value, 180)
+	}
+	sub := img.SubImage(image.Rect(2, 0, 4, 2))
+	copied := Box(sub, 2, 2)
+	if copied.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("Unexpected bounds: %v", copied.Bounds())
+	}
+	if value := copied.RGBAAt(0, 0).R; value != 120 {
+		t.Errorf("Unexpected red value: %d. Expected: %d", value, 120)
+	}
+	if empty := Box(img, 0, 1); !empty.Bounds().Empty() {
+		t.Errorf("Unexpected bounds: %v", empty.Bounds())
+	}
+}
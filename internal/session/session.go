@@ -0,0 +1,386 @@
+/*
+Package session implements the frame delivery to individual stream clients,
+with an optional bitrate limit, adaptive quality levels and a summary of the
+delivered frames.
+*/
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of frames queued for adaptive sessions.
+const queueSize = 4
+
+// Write time relative to the frame interval, above which the quality is
+// lowered and below which it is raised again.
+const (
+	stepDownLoad = 0.8
+	stepUpLoad   = 0.3
+)
+
+// Minimum time after a level change before the quality is lowered or raised.
+const (
+	stepDownDelay = time.Second
+	stepUpDelay   = 5 * time.Second
+)
+
+// Weight of new values for the moving averages of write times and intervals.
+const weight = 0.2
+
+type logEntry struct {
+	ID       string
+	Time     time.Time
+	Stream   string
+	RemoteIP string
+	Duration string
+	Frames   int
+	Skipped  int
+	Bytes    int64
+	FPS      float64
+	MaxKbps  int
+	Level    int
+}
+
+// Options configures a Session.
+// MaxKbps limits the bitrate in kilobits per second (unlimited if 0).
+// MaxLevel is the highest quality level an adaptive session steps down to,
+// adaptation is disabled if 0.
+type Options struct {
+	MaxKbps  int
+	MaxLevel int
+}
+
+// Summary describes the frames delivered during a session.
+// FPS is the effective frame rate of the delivered frames, while Level is the
+// current quality level.
+type Summary struct {
+	Duration time.Duration
+	Frames   int
+	Skipped  int
+	Bytes    int64
+	FPS      float64
+	MaxKbps  int
+	Level    int
+}
+
+type session struct {
+	w         io.Writer
+	options   Options
+	start     time.Time
+	next      time.Time
+	frames    int
+	skipped   int
+	bytes     int64
+	lock      *sync.Mutex
+	queue     chan []byte
+	levels    chan int
+	done      chan struct{}
+	stopped   chan struct{}
+	level     int
+	changed   time.Time
+	last      time.Time
+	interval  float64
+	writeTime float64
+	dropped   bool
+}
+
+// Session is an interface to deliver frames to a single client.
+// Each call of the Write method must provide a complete frame, which is either
+// written or skipped if it would exceed the maximum bitrate.
+// The Summary method returns the statistics of the delivered frames.
+// Adaptive sessions send their new quality level via the Levels channel, which
+// is nil for other sessions. The Close method stops adaptive sessions.
+type Session interface {
+	Write(p []byte) (int, error)
+	Summary() Summary
+	Levels() <-chan int
+	Close()
+}
+
+// average returns the moving average for the given new value.
+func average(current float64, value float64) float64 {
+	if current == 0 {
+		return value
+	}
+	return current + weight*(value-current)
+}
+
+// Write writes the given frame, unless the transfer time of the previous frame
+// at the maximum bitrate has not passed yet. Skipped frames are not reported as
+// error, as frames are never cut partway.
+// Adaptive sessions queue the frame instead and skip it if the queue is full.
+func (s *session) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if s.options.MaxKbps > 0 && now.Before(s.next) {
+		s.skipped++
+		return len(p), nil
+	}
+	if s.options.MaxKbps > 0 {
+		// The bitrate in kilobits per second equals bits per millisecond.
+		transfer := time.Duration(len(p)*8) * time.Millisecond /
+			time.Duration(s.options.MaxKbps)
+		s.next = now.Add(transfer)
+	}
+	if s.queue == nil {
+		n, err := s.w.Write(p)
+		s.frames++
+		s.bytes += int64(n)
+		return n, err
+	}
+	if !s.last.IsZero() {
+		s.interval = average(s.interval, float64(now.Sub(s.last)))
+	}
+	s.last = now
+	select {
+	case s.queue <- p:
+	default:
+		s.skipped++
+		s.dropped = true
+	}
+	return len(p), nil
+}
+
+// setLevel changes the quality level and sends it to the Levels channel,
+// replacing a level which has not been received yet.
+func (s *session) setLevel(level int, now time.Time) {
+	s.level = level
+	s.changed = now
+	select {
+	case <-s.levels:
+	default:
+	}
+	s.levels <- level
+}
+
+// adapt lowers the quality level if the client falls behind, which is the case
+// if frames were dropped, the queue backs up or writes take most of the frame
+// interval. The level is raised again once writes are fast and the queue is
+// empty.
+func (s *session) adapt(now time.Time) {
+	load := 0.0
+	if s.interval > 0 {
+		load = s.writeTime / s.interval
+	}
+	backlog := len(s.queue)
+	since := now.Sub(s.changed)
+	switch {
+	case s.level < s.options.MaxLevel && since >= stepDownDelay &&
+		(s.dropped || backlog > queueSize/2 || load > stepDownLoad):
+		s.setLevel(s.level+1, now)
+	case s.level > 0 && since >= stepUpDelay &&
+		!s.dropped && backlog == 0 && load < stepUpLoad:
+		s.setLevel(s.level-1, now)
+	}
+	s.dropped = false
+}
+
+// run writes the queued frames and adapts the quality level after each write.
+func (s *session) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case p := <-s.queue:
+			start := time.Now()
+			n, _ := s.w.Write(p)
+			now := time.Now()
+			s.lock.Lock()
+			s.frames++
+			s.bytes += int64(n)
+			s.writeTime = average(s.writeTime, float64(now.Sub(start)))
+			s.adapt(now)
+			s.lock.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Summary returns the statistics of the delivered frames.
+func (s *session) Summary() Summary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	duration := time.Since(s.start)
+	fps := 0.0
+	if duration > 0 {
+		fps = float64(s.frames) / duration.Seconds()
+	}
+	return Summary{
+		Duration: duration,
+		Frames:   s.frames,
+		Skipped:  s.skipped,
+		Bytes:    s.bytes,
+		FPS:      math.Round(fps*100) / 100,
+		MaxKbps:  s.options.MaxKbps,
+		Level:    s.level,
+	}
+}
+
+// Levels returns the channel of quality level changes.
+func (s *session) Levels() <-chan int {
+	return s.levels
+}
+
+// Close stops writing queued frames of adaptive sessions.
+// Once it returns, no further frames are written.
+func (s *session) Close() {
+	if s.done != nil {
+		close(s.done)
+		<-s.stopped
+	}
+}
+
+// Log prints the given session summary as JSON to stdout.
+func Log(id string, stream string, ip string, summary Summary) {
+	entry := &logEntry{
+		ID:       id,
+		Time:     time.Now().UTC(),
+		Stream:   stream,
+		RemoteIP: ip,
+		Duration: summary.Duration.Round(time.Millisecond).String(),
+		Frames:   summary.Frames,
+		Skipped:  summary.Skipped,
+		Bytes:    summary.Bytes,
+		FPS:      summary.FPS,
+		MaxKbps:  summary.MaxKbps,
+		Level:    summary.Level,
+	}
+	b, _ := json.Marshal(entry)
+	fmt.Println(string(b))
+}
+
+// New creates a new Session, which writes frames to the given Writer.
+// Adaptive sessions write frames in the background, until they are closed.
+func New(w io.Writer, options Options) Session {
+	s := &session{
+		w,
+		options,
+		time.Now(),
+		time.Time{},
+		0,
+		0,
+		0,
+		&sync.Mutex{},
+		nil,
+		nil,
+		nil,
+		nil,
+		0,
+		time.Time{},
+		time.Time{},
+		0,
+		0,
+		false,
+	}
+	if options.MaxLevel > 0 {
+		s.queue = make(chan []byte, queueSize)
+		s.levels = make(chan int, 1)
+		s.done = make(chan struct{})
+		s.stopped = make(chan struct{})
+		go s.run()
+	}
+	return s
+}
+
+// Info identifies the client of a session.
+type Info struct {
+	ID       string
+	Stream   string
+	RemoteIP string
+	Subject  string
+}
+
+// Status describes a session in the client listing.
+type Status struct {
+	ID       string
+	Stream   string
+	RemoteIP string
+	Subject  string
+	Since    time.Time
+	Frames   int
+	Skipped  int
+	FPS      float64
+	MaxKbps  int
+	Level    int
+}
+
+type tableEntry struct {
+	info    Info
+	session Session
+	since   time.Time
+}
+
+type table struct {
+	entries map[string]tableEntry
+	lock    *sync.Mutex
+}
+
+// Table is an interface to list the connected sessions.
+// Sessions are added and removed by ID with the Add and Remove methods, while
+// the Status method returns the status of all sessions, ordered by ID.
+type Table interface {
+	Add(info Info, s Session)
+	Remove(id string)
+	Status() []Status
+}
+
+// Add puts the given session into the Table.
+func (t *table) Add(info Info, s Session) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.entries[info.ID] = tableEntry{info, s, time.Now().UTC()}
+}
+
+// Remove deletes the session with the given ID from the Table.
+func (t *table) Remove(id string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.entries, id)
+}
+
+// Status returns the status of all sessions, ordered by numeric ID.
+func (t *table) Status() []Status {
+	t.lock.Lock()
+	entries := make([]tableEntry, 0, len(t.entries))
+	for _, entry := range t.entries {
+		entries = append(entries, entry)
+	}
+	t.lock.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].info.ID, entries[j].info.ID
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	status := make([]Status, len(entries))
+	for i, entry := range entries {
+		summary := entry.session.Summary()
+		status[i] = Status{
+			ID:       entry.info.ID,
+			Stream:   entry.info.Stream,
+			RemoteIP: entry.info.RemoteIP,
+			Subject:  entry.info.Subject,
+			Since:    entry.since,
+			Frames:   summary.Frames,
+			Skipped:  summary.Skipped,
+			FPS:      summary.FPS,
+			MaxKbps:  summary.MaxKbps,
+			Level:    summary.Level,
+		}
+	}
+	return status
+}
+
+// NewTable creates a new Table.
+func NewTable() Table {
+	return &table{make(map[string]tableEntry), &sync.Mutex{}}
+}
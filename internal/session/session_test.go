@@ -0,0 +1,195 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func outputHelper(fn func()) (stdout []byte, stderr []byte) {
+	outReader, outWriter, _ := os.Pipe()
+	errReader, errWriter, _ := os.Pipe()
+	originalOut := os.Stdout
+	originalErr := os.Stderr
+	os.Stdout = outWriter
+	os.Stderr = errWriter
+	fn()
+	outWriter.Close()
+	errWriter.Close()
+	stdout, _ = ioutil.ReadAll(outReader)
+	stderr, _ = ioutil.ReadAll(errReader)
+	os.Stdout = originalOut
+	os.Stderr = originalErr
+	return
+}
+
+func TestWrite(t *testing.T) {
+	var buffer bytes.Buffer
+	s := New(&buffer, Options{})
+	for i := 0; i < 3; i++ {
+		s.Write([]byte("banana"))
+	}
+	if buffer.String() != "bananabananabanana" {
+		t.Errorf("Unexpected output: %s", buffer.String())
+	}
+	summary := s.Summary()
+	if summary.Frames != 3 || summary.Skipped != 0 || summary.Bytes != 18 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
+
+func TestWriteWithMaxKbps(t *testing.T) {
+	var buffer bytes.Buffer
+	// 1000 bytes take 100ms at 80 kbps.
+	s := New(&buffer, Options{MaxKbps: 80})
+	frame := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		n, err := s.Write(frame)
+		if n != len(frame) || err != nil {
+			t.Errorf("Unexpected result: %d, %v", n, err)
+		}
+	}
+	if buffer.Len() != 1000 {
+		t.Errorf("Unexpected output size: %d. Expected: %d", buffer.Len(), 1000)
+	}
+	time.Sleep(110 * time.Millisecond)
+	s.Write(frame)
+	summary := s.Summary()
+	if summary.Frames != 2 || summary.Skipped != 2 || summary.Bytes != 2000 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if summary.FPS <= 0 || summary.FPS > 20 {
+		t.Errorf("Unexpected FPS: %f", summary.FPS)
+	}
+	if summary.MaxKbps != 80 {
+		t.Errorf("Unexpected MaxKbps: %d. Expected: %d", summary.MaxKbps, 80)
+	}
+}
+
+// slowWriter takes the given time for each write.
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestAdaptive(t *testing.T) {
+	if New(ioutil.Discard, Options{}).Levels() != nil {
+		t.Error("Unexpected levels channel without adaptation")
+	}
+	s := New(&slowWriter{30 * time.Millisecond}, Options{MaxLevel: 2})
+	defer s.Close()
+	for i := 0; i < 20; i++ {
+		s.Write([]byte("banana"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case level := <-s.Levels():
+		if level != 1 {
+			t.Errorf("Unexpected level: %d. Expected: %d", level, 1)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected: quality level not lowered")
+	}
+	summary := s.Summary()
+	if summary.Level != 1 || summary.Skipped == 0 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	now := time.Now()
+	s := New(ioutil.Discard, Options{MaxLevel: 3}).(*session)
+	s.Close()
+	for _, test := range []struct {
+		level     int
+		since     time.Duration
+		writeTime time.Duration
+		dropped   bool
+		expected  int
+	}{
+		{0, time.Minute, 90 * time.Millisecond, false, 1},
+		{0, time.Minute, 10 * time.Millisecond, true, 1},
+		{0, 500 * time.Millisecond, 90 * time.Millisecond, false, 0},
+		{3, time.Minute, 90 * time.Millisecond, true, 3},
+		{2, time.Minute, 10 * time.Millisecond, false, 1},
+		{2, 2 * time.Second, 10 * time.Millisecond, false, 2},
+		{2, time.Minute, 50 * time.Millisecond, false, 2},
+	} {
+		s.level = test.level
+		s.changed = now.Add(-test.since)
+		s.interval = float64(100 * time.Millisecond)
+		s.writeTime = float64(test.writeTime)
+		s.dropped = test.dropped
+		s.adapt(now)
+		if s.level != test.expected {
+			t.Errorf(
+				"Unexpected level for %+v: %d. Expected: %d",
+				test,
+				s.level,
+				test.expected,
+			)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable()
+	for _, id := range []string{"10", "9", "11"} {
+		table.Add(
+			Info{ID: id, Stream: "/", RemoteIP: "192.0.2.1"},
+			New(ioutil.Discard, Options{MaxKbps: 64}),
+		)
+	}
+	table.Remove("11")
+	status := table.Status()
+	if len(status) != 2 {
+		t.Fatalf("Unexpected status count: %d. Expected: %d", len(status), 2)
+	}
+	if status[0].ID != "9" || status[1].ID != "10" {
+		t.Errorf("Unexpected order: %s, %s", status[0].ID, status[1].ID)
+	}
+	if status[0].MaxKbps != 64 || status[0].RemoteIP != "192.0.2.1" {
+		t.Errorf("Unexpected status: %+v", status[0])
+	}
+}
+
+func TestLog(t *testing.T) {
+	stdout, stderr := outputHelper(func() {
+		Log("1", "/", "192.0.2.1", Summary{
+			Duration: 1500 * time.Millisecond,
+			Frames:   15,
+			Skipped:  5,
+			Bytes:    4096,
+			FPS:      10,
+			MaxKbps:  256,
+		})
+	})
+	if string(stderr) != "" {
+		t.Errorf("Unexpected stderr: %s", stderr)
+	}
+	var entry logEntry
+	json.Unmarshal(stdout, &entry)
+	if entry.ID != "1" {
+		t.Errorf("Unexpected 'ID' log: %s. Expected: %s", entry.ID, "1")
+	}
+	if entry.Duration != "1.5s" {
+		t.Errorf(
+			"Unexpected 'Duration' log: %s. Expected: %s",
+			entry.Duration,
+			"1.5s",
+		)
+	}
+	if entry.FPS != 10 {
+		t.Errorf("Unexpected 'FPS' log: %f. Expected: %f", entry.FPS, 10.0)
+	}
+	if entry.Skipped != 5 {
+		t.Errorf("Unexpected 'Skipped' log: %d. Expected: %d", entry.Skipped, 5)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Package signature implements HMAC-signed, expiring URLs.
+*/
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of signed URLs.
+const (
+	ExpiresParam   = "expires"
+	IPParam        = "ip"
+	SignatureParam = "signature"
+)
+
+// Errors returned by Verify.
+var (
+	ErrMissing = errors.New("missing signature")
+	ErrInvalid = errors.New("invalid signature")
+	ErrExpired = errors.New("expired signature")
+)
+
+func normalizePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of the given URL path,
+// stream parameters, expiry as Unix time and optional client IP.
+func Sign(
+	secret []byte,
+	path string,
+	params url.Values,
+	expires int64,
+	ip string,
+) string {
+	message := normalizePath(path)
+	if len(params) > 0 {
+		message += "?" + params.Encode()
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(
+		message + "\n" + strconv.FormatInt(expires, 10) + "\n" + ip,
+	))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL adds the expiry, the optional client IP and the signature as query
+// parameters to the given URL. The signature covers the given stream
+// parameters, while other parameters of the URL are left unsigned.
+func SignURL(
+	secret []byte,
+	u *url.URL,
+	params url.Values,
+	expires time.Time,
+	ip string,
+) {
+	u.Path = normalizePath(u.Path)
+	query := u.Query()
+	query.Del(IPParam)
+	query.Del(ExpiresParam)
+	query.Del(SignatureParam)
+	if ip != "" {
+		query.Set(IPParam, ip)
+	}
+	query.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(SignatureParam, Sign(secret, u.Path, params, expires.Unix(), ip))
+	u.RawQuery = query.Encode()
+}
+
+// Verify checks the signature of the given URL requested by the client with the
+// given IP at the given time. The given stream parameters of the request must
+// match the signed ones, while other parameters can be added to the URL.
+func Verify(
+	secret []byte,
+	u *url.URL,
+	params url.Values,
+	ip string,
+	now time.Time,
+) error {
+	query := u.Query()
+	signature, err := hex.DecodeString(query.Get(SignatureParam))
+	if err != nil {
+		return ErrInvalid
+	}
+	if len(signature) == 0 {
+		return ErrMissing
+	}
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	signedIP := query.Get(IPParam)
+	expected, _ := hex.DecodeString(
+		Sign(secret, u.Path, params, expires, signedIP),
+	)
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalid
+	}
+	if signedIP != "" && signedIP != ip {
+		return ErrInvalid
+	}
+	if now.Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
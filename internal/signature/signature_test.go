@@ -0,0 +1,99 @@
+package signature
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("banana")
+
+func TestSign(t *testing.T) {
+	signature := Sign(secret, "/", nil, 1588334400, "")
+	if len(signature) != 64 {
+		t.Errorf(
+			"Unexpected signature length: %d. Expected: %d",
+			len(signature),
+			64,
+		)
+	}
+	if Sign(secret, "", nil, 1588334400, "") != signature {
+		t.Error("Unexpected signature for empty path")
+	}
+	for _, other := range []string{
+		Sign(secret, "/frame", nil, 1588334400, ""),
+		Sign(secret, "/", url.Values{"display": {":3"}}, 1588334400, ""),
+		Sign(secret, "/", nil, 1588334401, ""),
+		Sign(secret, "/", nil, 1588334400, "192.0.2.1"),
+		Sign([]byte("apple"), "/", nil, 1588334400, ""),
+	} {
+		if other == signature {
+			t.Errorf("Unexpected identical signature: %s", other)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	signed, _ := url.Parse("http://localhost:9000")
+	SignURL(secret, signed, nil, expires, "")
+	if signed.Path != "/" {
+		t.Errorf("Unexpected signed URL: %s", signed)
+	}
+	// Parameters which do not select the stream can be added after signing.
+	signed.RawQuery += "&timeout=1s"
+	signedIP, _ := url.Parse("http://localhost:9000/frame")
+	SignURL(secret, signedIP, nil, expires, "192.0.2.1")
+	tampered, _ := url.Parse(signed.String())
+	tampered.Path = "/frame"
+	display := url.Values{"display": {":3"}}
+	// Only the given stream parameters are signed.
+	signedParams, _ := url.Parse("http://localhost:9000/?display=:3&timeout=1s")
+	SignURL(secret, signedParams, display, expires, "")
+	if signedParams.Query().Get("timeout") != "1s" {
+		t.Errorf("Unexpected signed URL: %s", signedParams)
+	}
+	tamperedParams, _ := url.Parse(
+		strings.Replace(signedParams.String(), "%3A3", "%3A4", 1),
+	)
+	missing, _ := url.Parse("http://localhost:9000/")
+	malformed, _ := url.Parse(signed.String())
+	query := malformed.Query()
+	query.Set(SignatureParam, "banana")
+	malformed.RawQuery = query.Encode()
+	tests := []struct {
+		u      *url.URL
+		params url.Values
+		ip     string
+		now    time.Time
+		err    error
+	}{
+		{signed, nil, "192.0.2.1", now, nil},
+		{signed, nil, "", expires.Add(-time.Second), nil},
+		{signed, nil, "", expires, ErrExpired},
+		{signedIP, nil, "192.0.2.1", now, nil},
+		{signedIP, nil, "192.0.2.2", now, ErrInvalid},
+		{tampered, nil, "", now, ErrInvalid},
+		{missing, nil, "", now, ErrMissing},
+		{malformed, nil, "", now, ErrInvalid},
+		{signedParams, display, "", now, nil},
+		{signedParams, nil, "", now, ErrInvalid},
+		{tamperedParams, url.Values{"display": {":4"}}, "", now, ErrInvalid},
+	}
+	for _, test := range tests {
+		err := Verify(secret, test.u, test.params, test.ip, test.now)
+		if err != test.err {
+			t.Errorf(
+				"Unexpected error for %s: %v. Expected: %v",
+				test.u,
+				err,
+				test.err,
+			)
+		}
+	}
+	if err := Verify([]byte("apple"), signed, nil, "", now); err != ErrInvalid {
+		t.Errorf("Unexpected error: %v. Expected: %v", err, ErrInvalid)
+	}
+}
@@ -0,0 +1,44 @@
+package text
+
+import (
+	"image"
+	"testing"
+)
+
+func TestWidth(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		scale int
+		width int
+	}{
+		{"", 1, 0},
+		{"A", 1, 5},
+		{"AB", 1, 11},
+		{"AB", 3, 33},
+	} {
+		if width := Width(test.text, test.scale); width != test.width {
+			t.Errorf(
+				"Unexpected width for %q at scale %d: %d. Expected: %d",
+				test.text,
+				test.scale,
+				width,
+				test.width,
+			)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 20, 20))
+	Draw(img, "i", image.Point{2, 4}, 2)
+	// The top bar of the I glyph spans the columns 1 to 3.
+	if img.GrayAt(2, 4).Y != 0 || img.GrayAt(4, 4).Y != 255 {
+		t.Error("Unexpected glyph pixels in the first row")
+	}
+	if img.GrayAt(9, 5).Y != 255 || img.GrayAt(10, 5).Y != 0 {
+		t.Error("Unexpected glyph pixels at the row end")
+	}
+	if img.GrayAt(4, 6).Y != 0 || img.GrayAt(6, 6).Y != 255 {
+		t.Error("Unexpected glyph pixels in the center column")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Package timelapse implements sampling frames of a stream at a fixed interval
+into a rolling buffer, which is played back as time-lapse.
+*/
+package timelapse
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+)
+
+// Default time-lapse settings, used for zero Options values.
+const (
+	DefaultFrames = 600
+	DefaultFPS    = 10
+)
+
+// MaxFPS is the maximum frame rate of the time-lapse playback.
+const MaxFPS = 100
+
+// Options configures a Sampler.
+// One frame per Interval is kept, up to the given number of Frames, which are
+// played back with the given FPS, limited to MaxFPS.
+type Options struct {
+	Interval time.Duration
+	Frames   int
+	FPS      float64
+}
+
+type sampler struct {
+	options Options
+	frames  []*frame.Frame
+	next    int
+	last    time.Time
+	lock    *sync.Mutex
+}
+
+// Sampler is an interface to collect time-lapse frames.
+// The Sample method adds the given frame if the interval since the last sample
+// has passed, replacing the oldest sample if the buffer is full.
+// The Frames method returns the samples in chronological order, while the
+// FrameInterval method returns the time between frames during playback.
+type Sampler interface {
+	Sample(f *frame.Frame)
+	Frames() []*frame.Frame
+	FrameInterval() time.Duration
+}
+
+// Sample adds the given frame if the interval since the last sample has passed.
+func (s *sampler) Sample(f *frame.Frame) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.last.IsZero() && f.Time.Sub(s.last) < s.options.Interval {
+		return
+	}
+	s.last = f.Time
+	if len(s.frames) < s.options.Frames {
+		s.frames = append(s.frames, f)
+		return
+	}
+	s.frames[s.next] = f
+	s.next = (s.next + 1) % len(s.frames)
+}
+
+// Frames returns the samples in chronological order.
+func (s *sampler) Frames() []*frame.Frame {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	frames := make([]*frame.Frame, 0, len(s.frames))
+	frames = append(frames, s.frames[s.next:]...)
+	return append(frames, s.frames[:s.next]...)
+}
+
+// FrameInterval returns the time between frames during playback.
+func (s *sampler) FrameInterval() time.Duration {
+	return time.Duration(float64(time.Second) / s.options.FPS)
+}
+
+// New creates a new Sampler.
+func New(options Options) Sampler {
+	if options.Frames <= 0 {
+		options.Frames = DefaultFrames
+	}
+	if options.FPS <= 0 {
+		options.FPS = DefaultFPS
+	}
+	if options.FPS > MaxFPS {
+		options.FPS = MaxFPS
+	}
+	return &sampler{options, nil, 0, time.Time{}, &sync.Mutex{}}
+}
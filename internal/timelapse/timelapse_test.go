@@ -0,0 +1,61 @@
+package timelapse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/frame"
+)
+
+func TestSample(t *testing.T) {
+	s := New(Options{Interval: time.Second, Frames: 3})
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		// Frames every 100ms over five seconds.
+		s.Sample(&frame.Frame{
+			Time:     start.Add(time.Duration(i) * 100 * time.Millisecond),
+			Sequence: uint64(i),
+		})
+	}
+	frames := s.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("Unexpected frame count: %d. Expected: %d", len(frames), 3)
+	}
+	for i, expected := range []uint64{20, 30, 40} {
+		if frames[i].Sequence != expected {
+			t.Errorf(
+				"Unexpected sequence at index %d: %d. Expected: %d",
+				i,
+				frames[i].Sequence,
+				expected,
+			)
+		}
+	}
+}
+
+func TestFrameInterval(t *testing.T) {
+	interval := New(Options{}).FrameInterval()
+	if interval != 100*time.Millisecond {
+		t.Errorf(
+			"Unexpected frame interval: %s. Expected: %s",
+			interval,
+			100*time.Millisecond,
+		)
+	}
+	interval = New(Options{FPS: 25}).FrameInterval()
+	if interval != 40*time.Millisecond {
+		t.Errorf(
+			"Unexpected frame interval: %s. Expected: %s",
+			interval,
+			40*time.Millisecond,
+		)
+	}
+	interval = New(Options{FPS: 1e12}).FrameInterval()
+	if interval != 10*time.Millisecond {
+		t.Errorf(
+			"Unexpected frame interval: %s. Expected: %s",
+			interval,
+			10*time.Millisecond,
+		)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Package validation implements the detection of truncated and corrupt JPEG
+frames.
+*/
+package validation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/jpeg"
+)
+
+// Mode identifies the extent of the validation.
+type Mode string
+
+const (
+	// Markers checks the start and end of image markers and the headers.
+	Markers Mode = "markers"
+	// Decode additionally decodes the complete image.
+	Decode Mode = "decode"
+)
+
+var (
+	// ErrMissingStart is returned if the start of image marker is missing.
+	ErrMissingStart = errors.New("missing start of image marker")
+	// ErrMissingEnd is returned if the end of image marker is missing.
+	ErrMissingEnd = errors.New("missing end of image marker")
+	// ErrInvalidHeader is returned if the image headers are malformed.
+	ErrInvalidHeader = errors.New("invalid image header")
+)
+
+// JPEG markers, which are prefixed with 0xFF.
+const (
+	markerSOF0  = 0xC0
+	markerSOF15 = 0xCF
+	markerDHT   = 0xC4
+	markerJPG   = 0xC8
+	markerDAC   = 0xCC
+	markerRST0  = 0xD0
+	markerRST7  = 0xD7
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerTEM   = 0x01
+)
+
+// ParseMode returns the Mode with the given name.
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(name); mode {
+	case Markers, Decode:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported validation mode: %s", name)
+}
+
+// isSOF returns true for the start of frame markers, which are all markers from
+// SOF0 to SOF15 except DHT, JPG and DAC.
+func isSOF(marker byte) bool {
+	return marker >= markerSOF0 && marker <= markerSOF15 &&
+		marker != markerDHT && marker != markerJPG && marker != markerDAC
+}
+
+// checkHeaders walks the marker segments up to the start of scan and checks
+// that they are complete and describe an image with valid dimensions.
+func checkHeaders(data []byte) error {
+	offset := 2
+	frame := false
+	for {
+		if offset+2 > len(data) || data[offset] != 0xFF {
+			return ErrInvalidHeader
+		}
+		marker := data[offset+1]
+		offset += 2
+		switch {
+		case marker == 0xFF:
+			// Fill byte, the next byte is the marker.
+			offset--
+			continue
+		case marker == markerTEM ||
+			(marker >= markerRST0 && marker <= markerRST7):
+			// Standalone markers without segment.
+			continue
+		case marker == markerSOI || marker == markerEOI:
+			return ErrInvalidHeader
+		}
+		if offset+2 > len(data) {
+			return ErrInvalidHeader
+		}
+		length := int(binary.BigEndian.Uint16(data[offset:]))
+		if length < 2 || offset+length > len(data) {
+			return ErrInvalidHeader
+		}
+		segment := data[offset+2 : offset+length]
+		if isSOF(marker) {
+			// Precision, height, width and number of components.
+			if len(segment) < 6 {
+				return ErrInvalidHeader
+			}
+			height := binary.BigEndian.Uint16(segment[1:])
+			width := binary.BigEndian.Uint16(segment[3:])
+			components := int(segment[5])
+			if height == 0 || width == 0 || components < 1 || components > 4 ||
+				len(segment) < 6+3*components {
+				return ErrInvalidHeader
+			}
+			frame = true
+		}
+		if marker == markerSOS {
+			if !frame {
+				return ErrInvalidHeader
+			}
+			return nil
+		}
+		offset += length
+	}
+}
+
+// Validate checks the given JPEG data with the given Mode.
+// It returns nil if the data passed the validation.
+func Validate(data []byte, mode Mode) error {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return ErrMissingStart
+	}
+	if !bytes.HasSuffix(data, []byte{0xFF, markerEOI}) {
+		return ErrMissingEnd
+	}
+	if err := checkHeaders(data); err != nil {
+		return err
+	}
+	if mode == Decode {
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
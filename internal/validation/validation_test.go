@@ -0,0 +1,75 @@
+package validation
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func jpegHelper() []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, nil)
+	return buffer.Bytes()
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []Mode{Markers, Decode} {
+		parsed, err := ParseMode(string(mode))
+		if err != nil || parsed != mode {
+			t.Errorf("Unexpected mode: %s. Expected: %s", parsed, mode)
+		}
+	}
+	if _, err := ParseMode("banana"); err == nil {
+		t.Error("Unexpected nil error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := jpegHelper()
+	// Truncated image data with an end of image marker, which has valid
+	// headers but cannot be decoded.
+	truncated := append(
+		append([]byte{}, valid[:len(valid)/2]...),
+		0xFF,
+		markerEOI,
+	)
+	// Image with a width of 0.
+	sof := bytes.Index(valid, []byte{0xFF, markerSOF0})
+	zeroWidth := append([]byte{}, valid...)
+	zeroWidth[sof+7], zeroWidth[sof+8] = 0, 0
+	// Image without the start of scan segment.
+	sos := bytes.Index(valid, []byte{0xFF, markerSOS})
+	headerOnly := append(append([]byte{}, valid[:sos]...), 0xFF, markerEOI)
+	tests := []struct {
+		data    []byte
+		mode    Mode
+		err     error
+		invalid bool
+	}{
+		{valid, Markers, nil, false},
+		{valid, Decode, nil, false},
+		{[]byte("banana"), Markers, ErrMissingStart, true},
+		{valid[:len(valid)-10], Markers, ErrMissingEnd, true},
+		{truncated, Markers, nil, false},
+		{truncated, Decode, nil, true},
+		{zeroWidth, Markers, ErrInvalidHeader, true},
+		{headerOnly, Markers, ErrInvalidHeader, true},
+	}
+	for i, test := range tests {
+		err := Validate(test.data, test.mode)
+		if (err != nil) != test.invalid ||
+			(test.err != nil && err != test.err) {
+			t.Errorf(
+				"Unexpected error for test %d: %v. Expected: %v",
+				i,
+				err,
+				test.err,
+			)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Package webhook implements the delivery of events as signed JSON POST requests.
+*/
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/event"
+)
+
+// SignatureHeader provides the hex encoded HMAC-SHA256 of the request body,
+// prefixed with "sha256=".
+const SignatureHeader = "X-Signature"
+
+// EventHeader provides the name of the delivered event.
+const EventHeader = "X-Event"
+
+// Default delivery settings, used for zero Options values.
+const (
+	DefaultAttempts  = 5
+	DefaultBackoff   = time.Second
+	DefaultTimeout   = 10 * time.Second
+	DefaultQueueSize = 100
+)
+
+// maxBackoff limits the exponential wait time between delivery attempts.
+const maxBackoff = time.Minute
+
+// Options configures a Webhook.
+// Events limits the delivered events to the given names, all events are
+// delivered if empty. Request bodies are signed if Secret is set.
+type Options struct {
+	URL       string
+	Secret    string
+	Events    []string
+	Attempts  int
+	Backoff   time.Duration
+	Timeout   time.Duration
+	QueueSize int
+}
+
+type webhook struct {
+	options Options
+	events  map[string]bool
+	queue   chan event.Event
+	client  *http.Client
+}
+
+// Webhook is an interface to deliver events to a URL.
+// The Handle method queues the given event without blocking, so it can be
+// subscribed to emitted events directly.
+type Webhook interface {
+	Handle(e event.Event)
+}
+
+// Sign returns the value of the signature header for the given body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *webhook) post(name string, body []byte) error {
+	req, err := http.NewRequest("POST", w.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, name)
+	if w.options.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.options.Secret, body))
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// deliver posts the event, retrying failed attempts with exponential backoff.
+func (w *webhook) deliver(e event.Event) {
+	body, _ := json.Marshal(e)
+	backoff := w.options.Backoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(e.Event, body)
+		if err == nil {
+			return
+		}
+		if attempt >= w.options.Attempts {
+			log.Printf(
+				"Webhook %s: giving up on %s event after %d attempts: %s",
+				w.options.URL,
+				e.Event,
+				attempt,
+				err,
+			)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (w *webhook) run() {
+	for e := range w.queue {
+		w.deliver(e)
+	}
+}
+
+// Handle queues the given event for delivery, unless it is filtered out.
+// If the queue is full, e.g. due to a slow endpoint, the event is dropped.
+func (w *webhook) Handle(e event.Event) {
+	if len(w.events) > 0 && !w.events[e.Event] {
+		return
+	}
+	select {
+	case w.queue <- e:
+	default:
+		log.Printf(
+			"Webhook %s: queue full, dropped %s event",
+			w.options.URL,
+			e.Event,
+		)
+	}
+}
+
+// New creates a new Webhook, which delivers queued events in the background.
+func New(options Options) Webhook {
+	if options.Attempts <= 0 {
+		options.Attempts = DefaultAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultBackoff
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	events := make(map[string]bool)
+	for _, name := range options.Events {
+		events[name] = true
+	}
+	w := &webhook{
+		options,
+		events,
+		make(chan event.Event, options.QueueSize),
+		&http.Client{Timeout: options.Timeout},
+	}
+	go w.run()
+	return w
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blueimp/mjpeg-server/internal/event"
+)
+
+type delivery struct {
+	name      string
+	signature string
+	body      []byte
+}
+
+func receiverHelper(failures int32) (*httptest.Server, chan delivery) {
+	deliveries := make(chan delivery, 10)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= failures {
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			deliveries <- delivery{
+				req.Header.Get(EventHeader),
+				req.Header.Get(SignatureHeader),
+				body,
+			}
+		},
+	))
+	return server, deliveries
+}
+
+func waitHelper(t *testing.T, deliveries chan delivery) delivery {
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected: no delivery")
+	}
+	return delivery{}
+}
+
+// logHelper is a log output, which passes each log message to a channel.
+type logHelper chan string
+
+func (l logHelper) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func (l logHelper) wait(t *testing.T, text string) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case message := <-l:
+			if strings.Contains(message, text) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Unexpected: no log with: %s", text)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	server, deliveries := receiverHelper(0)
+	defer server.Close()
+	w := New(Options{
+		URL:    server.URL,
+		Secret: "banana",
+		Events: []string{"clients-start"},
+	})
+	w.Handle(event.Event{Event: "recording-start", Stream: "/"})
+	w.Handle(event.Event{
+		Event:  "clients-start",
+		Stream: "/",
+		Data:   map[string]interface{}{"ID": "1"},
+	})
+	d := waitHelper(t, deliveries)
+	if d.name != "clients-start" {
+		t.Errorf("Unexpected event: %s. Expected: %s", d.name, "clients-start")
+	}
+	if d.signature != Sign("banana", d.body) {
+		t.Errorf("Unexpected signature: %s", d.signature)
+	}
+	var e event.Event
+	json.Unmarshal(d.body, &e)
+	if e.Stream != "/" || e.Data["ID"] != "1" {
+		t.Errorf("Unexpected event body: %s", d.body)
+	}
+	select {
+	case d := <-deliveries:
+		t.Errorf("Unexpected delivery: %s", d.name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandleWithRetries(t *testing.T) {
+	server, deliveries := receiverHelper(2)
+	defer server.Close()
+	w := New(Options{URL: server.URL, Backoff: time.Millisecond})
+	w.Handle(event.Event{Event: "recording-crash", Stream: "/"})
+	d := waitHelper(t, deliveries)
+	if d.name != "recording-crash" {
+		t.Errorf("Unexpected event: %s. Expected: %s", d.name, "recording-crash")
+	}
+	if d.signature != "" {
+		t.Errorf("Unexpected signature: %s", d.signature)
+	}
+	output := make(logHelper, 10)
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+	w = New(Options{URL: server.URL + "/banana", Attempts: 1})
+	server.Close()
+	w.Handle(event.Event{Event: "recording-stop", Stream: "/"})
+	output.wait(t, "giving up on recording-stop")
+}
+
+func TestHandleWithSlowEndpoint(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			<-block
+		},
+	))
+	defer server.Close()
+	defer close(block)
+	w := New(Options{URL: server.URL, QueueSize: 1})
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			w.Handle(event.Event{Event: "clients-end", Stream: "/"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected: Handle blocked by slow endpoint")
+	}
+	if !strings.Contains(output.String(), "queue full") {
+		t.Error("Unexpected: no log for dropped events")
+	}
+}
@@ -1,14 +1,51 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/blueimp/mjpeg-server/internal/access"
+	"github.com/blueimp/mjpeg-server/internal/analysis"
+	"github.com/blueimp/mjpeg-server/internal/auth"
+	"github.com/blueimp/mjpeg-server/internal/config"
+	"github.com/blueimp/mjpeg-server/internal/crop"
+	"github.com/blueimp/mjpeg-server/internal/event"
+	"github.com/blueimp/mjpeg-server/internal/export"
+	"github.com/blueimp/mjpeg-server/internal/frame"
+	"github.com/blueimp/mjpeg-server/internal/jwt"
+	"github.com/blueimp/mjpeg-server/internal/limit"
+	"github.com/blueimp/mjpeg-server/internal/listener"
+	"github.com/blueimp/mjpeg-server/internal/mask"
+	"github.com/blueimp/mjpeg-server/internal/metrics"
+	"github.com/blueimp/mjpeg-server/internal/mosaic"
+	"github.com/blueimp/mjpeg-server/internal/params"
+	"github.com/blueimp/mjpeg-server/internal/placeholder"
+	"github.com/blueimp/mjpeg-server/internal/quality"
+	"github.com/blueimp/mjpeg-server/internal/recording"
 	"github.com/blueimp/mjpeg-server/internal/registry"
 	"github.com/blueimp/mjpeg-server/internal/request"
+	"github.com/blueimp/mjpeg-server/internal/session"
+	"github.com/blueimp/mjpeg-server/internal/signature"
+	"github.com/blueimp/mjpeg-server/internal/timelapse"
+	"github.com/blueimp/mjpeg-server/internal/validation"
+	"github.com/blueimp/mjpeg-server/internal/webhook"
 )
 
 var (
@@ -17,14 +54,254 @@ var (
 	Version     = "dev"
 	showVersion = flag.Bool("v", false, "Output version and exit")
 	directStart = flag.Bool("d", false, "Start command directly")
-	addr        = flag.String("a", ":9000", "TCP listen address")
+	addrs       = stringsVar("a", "Listen address, repeatable (default :9000)")
+	configFile  = flag.String("c", "", "Configuration file")
 	urlPath     = flag.String("p", "/", "URL path")
 	boundary    = flag.String("b", "ffmpeg", "Multipart boundary")
 	command     string
 	args        []string
 	reg         registry.Registry
+	pool        registry.Pool
+	idCounter   uint64
+	rules       access.Rules
+	limiter     = limit.New(0, 0)
+	secret      []byte
+	queryParams params.Params
+	keys        *jwt.Keys
+	masks       = mask.NewStore(nil)
+	crops       = map[string]image.Rectangle{}
+	mosaics     = map[string]registry.Registry{}
+	maxKbps     int
+	sessions    = session.NewTable()
 )
 
+var (
+	blackTimeout = flag.Duration(
+		"black-timeout",
+		0,
+		"Black screen detection timeout (disabled if 0)",
+	)
+	frozenTimeout = flag.Duration(
+		"frozen-timeout",
+		0,
+		"Frozen screen detection timeout (disabled if 0)",
+	)
+	analysisInterval = flag.Duration(
+		"analysis-interval",
+		time.Second,
+		"Minimum interval between analyzed frames",
+	)
+	metricsPath = flag.String(
+		"metrics-path",
+		"",
+		"Metrics URL path (disabled if empty)",
+	)
+	readyPath = flag.String(
+		"ready-path",
+		"",
+		"Readiness URL path (disabled if empty)",
+	)
+	framePath = flag.String(
+		"frame-path",
+		"",
+		"Single frame URL path (disabled if empty)",
+	)
+	socketMode = flag.String(
+		"socket-mode",
+		"0660",
+		"File mode of Unix domain sockets",
+	)
+	trustedProxies = flag.String(
+		"trusted-proxies",
+		"",
+		"Comma-separated list of trusted proxy CIDRs, IPs or unix",
+	)
+	inputBoundary = flag.String(
+		"input-boundary",
+		"",
+		"Expected multipart boundary of the command output (optional)",
+	)
+	maxClients = flag.Int(
+		"max-clients",
+		0,
+		"Maximum number of connected clients (unlimited if 0)",
+	)
+	maxClientsPerIP = flag.Int(
+		"max-clients-per-ip",
+		0,
+		"Maximum number of connected clients per IP (unlimited if 0)",
+	)
+	secretFile = flag.String(
+		"secret-file",
+		"",
+		"File with the shared secret to require signed URLs (optional)",
+	)
+	jwtKeys = flag.String(
+		"jwt-keys",
+		"",
+		"PEM or JWKS file with keys to require JWT authorization (optional)",
+	)
+	preStartHook = flag.String(
+		"pre-start-hook",
+		"",
+		"Executable to run before the command starts, aborts the start on failure",
+	)
+	postStartHook = flag.String(
+		"post-start-hook",
+		"",
+		"Executable to run after the command started",
+	)
+	postStopHook = flag.String(
+		"post-stop-hook",
+		"",
+		"Executable to run after the command stopped",
+	)
+	hookTimeout = flag.Duration(
+		"hook-timeout",
+		recording.DefaultHookTimeout,
+		"Maximum run time of each hook",
+	)
+	stopSignal = flag.String(
+		"stop-signal",
+		recording.DefaultStopSignal,
+		"Signal to stop the command process group",
+	)
+	stopTimeout = flag.Duration(
+		"stop-timeout",
+		recording.DefaultStopTimeout,
+		"Grace period before the command is killed",
+	)
+	stallTimeout = flag.Duration(
+		"stall-timeout",
+		0,
+		"Restart the command if no frame arrives in time (e.g. 10s)",
+	)
+	validationMode = flag.String(
+		"validate",
+		"",
+		"Drop corrupt frames: markers or decode (disabled if empty)",
+	)
+	showPlaceholder = flag.Bool(
+		"placeholder",
+		false,
+		"Send placeholder frames while the command provides none",
+	)
+	placeholderImage = flag.String(
+		"placeholder-image",
+		"",
+		"JPEG or PNG placeholder image (generated card if empty)",
+	)
+	placeholderStatus = flag.Bool(
+		"placeholder-status",
+		true,
+		"Draw the status text onto the placeholder image",
+	)
+	placeholderInterval = flag.Duration(
+		"placeholder-interval",
+		placeholder.DefaultInterval,
+		"Interval between placeholder frames",
+	)
+	maskSpecs = stringsVar(
+		"mask",
+		"Privacy mask as x,y,width,height[,fill|pixelate], repeatable",
+	)
+	maskPath = flag.String(
+		"mask-path",
+		"",
+		"Privacy masks API URL path for listener Admins (disabled if empty)",
+	)
+	bufferDuration = flag.Duration(
+		"buffer",
+		0,
+		"Duration of recent frames kept in memory for exports (e.g. 30s)",
+	)
+	gifPath = flag.String(
+		"gif-path",
+		"",
+		"GIF export URL path (disabled if empty)",
+	)
+	timeLapseInterval = flag.Duration(
+		"timelapse-interval",
+		0,
+		"Interval between time-lapse samples (disabled if 0)",
+	)
+	timeLapseFrames = flag.Int(
+		"timelapse-frames",
+		timelapse.DefaultFrames,
+		"Maximum number of time-lapse samples",
+	)
+	timeLapseFPS = flag.Float64(
+		"timelapse-fps",
+		timelapse.DefaultFPS,
+		"Frame rate of the time-lapse playback (at most 100)",
+	)
+	adaptiveQuality = flag.Bool(
+		"adaptive-quality",
+		false,
+		"Lower the frame quality for clients which fall behind",
+	)
+	clientsPath = flag.String(
+		"clients-path",
+		"",
+		"Connected clients URL path for listener Admins (disabled if empty)",
+	)
+	paramSpecs = stringsVar(
+		"param",
+		"Allowed query parameter for args placeholders as name=regex, repeatable",
+	)
+)
+
+// Default and maximum wait time for the single frame endpoint.
+const (
+	defaultFrameTimeout = 2 * time.Second
+	maxFrameTimeout     = time.Minute
+)
+
+// retryAfter is the number of seconds clients are asked to wait before
+// retrying a connection rejected due to client limits.
+const retryAfter = "5"
+
+// defaultGIFRange is the default time range of GIF exports, relative to the
+// request time.
+const defaultGIFRange = -10 * time.Second
+
+// Default width and maximum size of GIF exports via the GIF endpoint, which
+// limit the memory of a single export request.
+const (
+	defaultGIFWidth = 640
+	maxGIFFrames    = 300
+	maxGIFPixels    = 64 << 20
+)
+
+// maxMasksSize is the maximum size of privacy mask API request bodies.
+const maxMasksSize = 1 << 16
+
+// frameClient is registered as client while waiting for a single frame, to
+// start the recording on demand.
+type frameClient struct {
+	id string
+}
+
+func (c *frameClient) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// tokenKey is the request context key for the bearer token verification.
+type tokenKey struct{}
+
+// adminKey is the request context key for requests authenticated as admin.
+type adminKey struct{}
+
+type token struct {
+	claims *jwt.Claims
+	err    error
+}
+
+type readiness struct {
+	Ready   bool
+	Streams map[string]registry.Status
+}
+
 func setHeaders(header http.Header) {
 	// Provide the multipart boundary via MJPEG over HTTP content-type header.
 	// See also:
@@ -43,23 +320,872 @@ func setHeaders(header http.Header) {
 	header.Set("Connection", "close")
 }
 
+// generateID returns an auto-incrementing request ID.
+func generateID() string {
+	return strconv.FormatUint(atomic.AddUint64(&idCounter, 1), 10)
+}
+
+func readyHandler(res http.ResponseWriter, req *http.Request) {
+	result := &readiness{Ready: true, Streams: map[string]registry.Status{}}
+	if reg != nil {
+		result.Streams[*urlPath] = reg.Status()
+	}
+	if pool != nil {
+		for name, status := range pool.Status() {
+			result.Streams[name] = status
+		}
+	}
+	for _, status := range result.Streams {
+		if status.Black || status.Frozen {
+			result.Ready = false
+		}
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	if !result.Ready {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(res).Encode(result)
+}
+
+// isAdminPath returns true if the given URL path is an admin endpoint.
+func isAdminPath(path string) bool {
+	return (*maskPath != "" && path == *maskPath) ||
+		(*clientsPath != "" && path == *clientsPath)
+}
+
+// checkAdmin responds with a 404 status and returns false if the request has
+// not been authenticated as admin.
+func checkAdmin(res http.ResponseWriter, req *http.Request) bool {
+	if admin, _ := req.Context().Value(adminKey{}).(bool); !admin {
+		res.WriteHeader(http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// maskHandler responds with the current privacy masks as JSON array and
+// replaces them with the masks of PUT requests. It is only served to requests
+// authenticated as admin.
+// Changes are emitted as "masks-change" event for auditing.
+func maskHandler(res http.ResponseWriter, req *http.Request, id string) {
+	if !checkAdmin(res, req) {
+		return
+	}
+	if req.Method != "GET" && req.Method != "PUT" {
+		res.Header().Set("Allow", "GET, PUT")
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Method == "PUT" {
+		var specs []string
+		body := http.MaxBytesReader(res, req.Body, maxMasksSize)
+		if err := json.NewDecoder(body).Decode(&specs); err != nil {
+			http.Error(res, "invalid JSON array", http.StatusBadRequest)
+			return
+		}
+		updated, err := mask.Parse(specs)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := masks.Set(updated)
+		event.Emit("masks-change", *urlPath, map[string]interface{}{
+			"ID":       id,
+			"RemoteIP": request.ClientIP(req),
+			"Subject":  request.Subject(req),
+			"Masks":    updated.Specs(),
+			"Previous": previous.Specs(),
+		})
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(res).Encode(masks.Masks().Specs())
+}
+
+// clientsHandler responds with the connected stream clients as JSON array.
+// It is only served to requests authenticated as admin.
+func clientsHandler(res http.ResponseWriter, req *http.Request) {
+	if !checkAdmin(res, req) {
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(res).Encode(sessions.Status())
+}
+
+// gifOptions returns the export options from the given query parameters.
+func gifOptions(query url.Values) (export.Options, error) {
+	options := export.Options{}
+	var err error
+	if value := query.Get("fps"); value != "" {
+		options.FPS, err = strconv.ParseFloat(value, 64)
+		if err != nil || options.FPS <= 0 || options.FPS > export.MaxFPS {
+			return options, errors.New("invalid fps")
+		}
+	}
+	if value := query.Get("width"); value != "" {
+		options.MaxWidth, err = strconv.Atoi(value)
+		if err != nil || options.MaxWidth < 0 {
+			return options, errors.New("invalid width")
+		}
+	}
+	if value := query.Get("colors"); value != "" {
+		options.Colors, err = strconv.Atoi(value)
+		if err != nil || options.Colors < 2 || options.Colors > 256 {
+			return options, errors.New("invalid colors")
+		}
+	}
+	return options, nil
+}
+
+// gifHandler responds with the buffered frames of the requested time range as
+// animated GIF. The time range defaults to the last 10 seconds.
+// Exports exceeding the maximum size are rejected with a 400 status.
+func gifHandler(res http.ResponseWriter, req *http.Request, id string) {
+	if !checkAccess(res, req, id) {
+		return
+	}
+	release := acquire(res, req, id)
+	if release == nil {
+		return
+	}
+	defer release()
+	query := req.URL.Query()
+	now := time.Now()
+	from, to := now.Add(defaultGIFRange), now
+	var err error
+	if value := query.Get("from"); value != "" {
+		from, err = export.ParseTime(value, now)
+	}
+	if value := query.Get("to"); value != "" && err == nil {
+		to, err = export.ParseTime(value, now)
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options, err := gifOptions(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Get("width") == "" {
+		options.MaxWidth = defaultGIFWidth
+	}
+	options.MaxFrames = maxGIFFrames
+	options.MaxPixels = maxGIFPixels
+	reg, releaseRegistry := streamRegistry(res, req)
+	if reg == nil {
+		return
+	}
+	defer releaseRegistry()
+	var buffer bytes.Buffer
+	err = export.GIF(&buffer, reg.Frames(from, to), options)
+	if err == export.ErrNoFrames {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == export.ErrTooLarge {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	header := res.Header()
+	header.Set("Content-Type", "image/gif")
+	header.Set("Content-Length", strconv.Itoa(buffer.Len()))
+	header.Set("Cache-Control", "no-store")
+	res.Write(buffer.Bytes())
+}
+
+// parseAfter returns a function matching frames newer than the given RFC 3339
+// timestamp or sequence number. An empty value matches all frames.
+func parseAfter(value string) (func(f *frame.Frame) bool, error) {
+	if value == "" {
+		return func(f *frame.Frame) bool { return true }, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return func(f *frame.Frame) bool { return f.Time.After(timestamp) }, nil
+	}
+	sequence, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, errors.New("after must be an RFC 3339 timestamp or number")
+	}
+	return func(f *frame.Frame) bool { return f.Sequence > sequence }, nil
+}
+
+// authenticate verifies the bearer token if JWT keys are set and returns the
+// request with the verification result and the token subject for logging.
+func authenticate(req *http.Request) *http.Request {
+	if keys == nil {
+		return req
+	}
+	claims, err := keys.Verify(jwt.FromRequest(req), time.Now())
+	if err == nil {
+		req = request.WithSubject(req, claims.Subject)
+	}
+	return req.WithContext(
+		context.WithValue(req.Context(), tokenKey{}, &token{claims, err}),
+	)
+}
+
+// streamParams returns the query parameters which select the stream, which are
+// the crop and timelapse parameters and the given command parameters.
+func streamParams(query url.Values, commandParams params.Params) url.Values {
+	names := []string{"crop", "timelapse"}
+	for _, param := range commandParams {
+		names = append(names, param.Name)
+	}
+	selected := url.Values{}
+	for _, name := range names {
+		if values, ok := query[name]; ok {
+			selected[name] = values
+		}
+	}
+	return selected
+}
+
+// streamName returns the URL path of the requested stream with the sorted
+// parameters selecting the stream appended as query, e.g. "/?display=%3A3".
+// Mosaics are named by their URL path.
+func streamName(req *http.Request) string {
+	if mosaics[req.URL.Path] != nil {
+		return req.URL.Path
+	}
+	selected := streamParams(req.URL.Query(), queryParams)
+	if len(selected) == 0 {
+		return *urlPath
+	}
+	return *urlPath + "?" + selected.Encode()
+}
+
+// checkToken responds with a 401 status for missing or invalid bearer tokens
+// and with a 403 status if the token claims do not grant access to the stream.
+// Streams selected by parameters must be granted by name with parameters and
+// mosaics by their path.
+func checkToken(res http.ResponseWriter, req *http.Request) bool {
+	t, ok := req.Context().Value(tokenKey{}).(*token)
+	if !ok {
+		t = &token{nil, jwt.ErrMissing}
+	}
+	if t.err != nil {
+		jwt.Challenge(res, t.err)
+		return false
+	}
+	if !t.claims.Allows(streamName(req)) {
+		http.Error(res, "stream not granted by token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkAccess responds with an error status and returns false if the URL
+// signature or bearer token is required but not valid or the access rules of
+// the stream deny access for the client.
+func checkAccess(res http.ResponseWriter, req *http.Request, id string) bool {
+	ip := request.ClientIP(req)
+	if secret != nil {
+		err := signature.Verify(
+			secret,
+			req.URL,
+			streamParams(req.URL.Query(), queryParams),
+			ip,
+			time.Now(),
+		)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	if keys != nil && !checkToken(res, req) {
+		return false
+	}
+	allowed, rule := rules.Check(ip)
+	if !allowed {
+		access.Log(id, *urlPath, ip, allowed, rule)
+		res.WriteHeader(http.StatusForbidden)
+	}
+	return allowed
+}
+
+// acquire registers the client with the connection limiter and returns the
+// function to release it again. If a limit is exceeded, it responds with a 503
+// status and returns nil.
+func acquire(res http.ResponseWriter, req *http.Request, id string) func() {
+	release, ok := limiter.Acquire(id, *urlPath, request.ClientIP(req))
+	if !ok {
+		res.Header().Set("Retry-After", retryAfter)
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	}
+	return release
+}
+
+// cropRegion returns the crop region for the given preset name or region in
+// the format "x,y,width,height".
+func cropRegion(value string) (image.Rectangle, error) {
+	if rect, ok := crops[value]; ok {
+		return rect, nil
+	}
+	if !strings.Contains(value, ",") {
+		return image.Rectangle{}, errors.New("unknown crop preset: " + value)
+	}
+	return crop.Parse(value)
+}
+
+// resolveRegistry returns the registry for the given stream parameters and a
+// function to release it again.
+// For parameters with a crop region, the registry of the cropped frames is
+// returned, which is shared by all clients requesting the same region.
+// For parameters with timelapse, the registry of the time-lapse playback is
+// returned.
+func resolveRegistry(query url.Values) (registry.Registry, func(), error) {
+	var rect image.Rectangle
+	if value := query.Get("crop"); value != "" {
+		var err error
+		rect, err = cropRegion(value)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	_, timeLapse := query["timelapse"]
+	if timeLapse && *timeLapseInterval <= 0 {
+		return nil, nil, errors.New("time-lapse is disabled")
+	}
+	if timeLapse && !rect.Empty() {
+		return nil, nil, errors.New("crop cannot be combined with timelapse")
+	}
+	source, release := reg, func() {}
+	if pool != nil {
+		values, err := queryParams.Values(query)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, release = pool.Acquire(
+			params.Key(values),
+			params.Expand(args, values),
+		)
+	}
+	if timeLapse {
+		playback, releasePlayback := source.TimeLapse()
+		return playback, func() {
+			releasePlayback()
+			release()
+		}, nil
+	}
+	if rect.Empty() {
+		return source, release, nil
+	}
+	cropped, releaseCropped := source.Derive(
+		"crop="+crop.Key(rect),
+		func(data []byte) ([]byte, error) {
+			return crop.Apply(data, rect)
+		},
+	)
+	return cropped, func() {
+		releaseCropped()
+		release()
+	}, nil
+}
+
+// streamRegistry returns the registry for the parameters of the requested
+// stream and a function to release it again. If the parameters are not valid,
+// it responds with a 400 status and returns nil.
+func streamRegistry(res http.ResponseWriter, req *http.Request) (
+	registry.Registry,
+	func(),
+) {
+	reg, release, err := resolveRegistry(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return nil, nil
+	}
+	return reg, release
+}
+
+// newMosaic creates the registry of the given mosaic configuration, which
+// acquires the registries of its sources while it has clients.
+// Sources are labeled with their parameters or the stream path.
+func newMosaic(c config.Mosaic) (registry.Registry, error) {
+	sources := make([]registry.SourceFunc, len(c.Sources))
+	labels := make([]string, len(c.Sources))
+	for i, source := range c.Sources {
+		query, _ := url.ParseQuery(source)
+		// Check the parameters, as sources are acquired on demand.
+		_, release, err := resolveRegistry(query)
+		if err != nil {
+			return nil, fmt.Errorf("mosaic %s: %s", c.Path, err)
+		}
+		release()
+		sources[i] = func() (registry.Registry, func()) {
+			reg, release, _ := resolveRegistry(query)
+			return reg, release
+		}
+		labels[i] = source
+		if source == "" {
+			labels[i] = *urlPath
+		}
+	}
+	fps := c.FPS
+	if fps == 0 {
+		fps = mosaic.DefaultFPS
+	}
+	grid := mosaic.New(mosaic.Options{
+		Labels:  labels,
+		Columns: c.Columns,
+		Width:   c.Width,
+	})
+	return registry.NewComposite(
+		registry.Options{Name: c.Path, Boundary: *boundary},
+		registry.Composite{
+			Sources:  sources,
+			Compose:  grid.Render,
+			Interval: time.Duration(float64(time.Second) / fps),
+		},
+	), nil
+}
+
+func frameHandler(res http.ResponseWriter, req *http.Request, id string) {
+	if !checkAccess(res, req, id) {
+		return
+	}
+	release := acquire(res, req, id)
+	if release == nil {
+		return
+	}
+	defer release()
+	reg, releaseRegistry := streamRegistry(res, req)
+	if reg == nil {
+		return
+	}
+	defer releaseRegistry()
+	query := req.URL.Query()
+	match, err := parseAfter(query.Get("after"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeout := defaultFrameTimeout
+	if value := query.Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			http.Error(res, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+		if timeout > maxFrameTimeout {
+			timeout = maxFrameTimeout
+		}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	client := &frameClient{id}
+	reg.Add(id, client)
+	f := reg.Frame(ctx, match)
+	reg.Remove(id, client)
+	if f == nil {
+		res.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	header := res.Header()
+	header.Set("Content-Type", "image/jpeg")
+	header.Set("Content-Length", strconv.Itoa(len(f.Data)))
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Frame-Timestamp", f.Time.UTC().Format(time.RFC3339Nano))
+	header.Set("X-Frame-Sequence", strconv.FormatUint(f.Sequence, 10))
+	res.Write(f.Data)
+}
+
+// clientKbps returns the bitrate limit of a client for the given maxkbps
+// parameter, which can only lower the configured limit of the stream.
+// An empty or zero value keeps the configured limit.
+func clientKbps(value string) (int, error) {
+	if value == "" {
+		return maxKbps, nil
+	}
+	kbps, err := strconv.Atoi(value)
+	if err != nil || kbps < 0 {
+		return 0, errors.New("invalid maxkbps")
+	}
+	if kbps == 0 || (maxKbps > 0 && kbps > maxKbps) {
+		return maxKbps, nil
+	}
+	return kbps, nil
+}
+
+// serveSession sends the frames of the given registry to the client until the
+// given context is done. For adaptive sessions, the client is moved to the
+// registry of the quality variant of each level chosen by the session.
+func serveSession(
+	ctx context.Context,
+	id string,
+	reg registry.Registry,
+	client session.Session,
+) {
+	current, release := reg, func() {}
+	current.Add(id, client)
+	for {
+		select {
+		case <-ctx.Done():
+			current.Remove(id, client)
+			release()
+			return
+		case level := <-client.Levels():
+			next, releaseNext := reg, func() {}
+			if level > 0 {
+				next, releaseNext = reg.Variant(
+					quality.Key(level),
+					func(data []byte) ([]byte, error) {
+						return quality.Apply(data, level)
+					},
+				)
+			}
+			// Add the client to the next registry first, to keep the recording
+			// running.
+			next.Add(id, client)
+			current.Remove(id, client)
+			release()
+			current, release = next, releaseNext
+		}
+	}
+}
+
 func requestHandler(res http.ResponseWriter, req *http.Request) {
-	id := reg.GenerateID()
+	id := generateID()
+	req = authenticate(req)
 	request.Log(req, id)
+	if *maskPath != "" && req.URL.Path == *maskPath {
+		maskHandler(res, req, id)
+		return
+	}
 	if req.Method != "GET" {
 		res.Header().Set("Allow", "GET")
 		res.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	if req.URL.Path != *urlPath {
+	switch {
+	case req.URL.Path == *urlPath:
+	case *metricsPath != "" && req.URL.Path == *metricsPath:
+		metrics.Handler(res, req)
+		return
+	case *readyPath != "" && req.URL.Path == *readyPath:
+		readyHandler(res, req)
+		return
+	case *framePath != "" && req.URL.Path == *framePath:
+		frameHandler(res, req, id)
+		return
+	case *gifPath != "" && req.URL.Path == *gifPath:
+		gifHandler(res, req, id)
+		return
+	case *clientsPath != "" && req.URL.Path == *clientsPath:
+		clientsHandler(res, req)
+		return
+	case mosaics[req.URL.Path] != nil:
+	default:
 		res.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if !checkAccess(res, req, id) {
+		return
+	}
+	kbps, err := clientKbps(req.URL.Query().Get("maxkbps"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	release := acquire(res, req, id)
+	if release == nil {
+		return
+	}
+	defer release()
+	reg, releaseRegistry := mosaics[req.URL.Path], func() {}
+	if reg == nil {
+		reg, releaseRegistry = streamRegistry(res, req)
+		if reg == nil {
+			return
+		}
+	}
+	defer releaseRegistry()
 	setHeaders(res.Header())
-	reg.Add(id, res)
-	// Wait until the client connection is closed.
-	<-req.Context().Done()
-	reg.Remove(id, res)
+	options := session.Options{MaxKbps: kbps}
+	if *adaptiveQuality {
+		options.MaxLevel = quality.MaxLevel
+	}
+	client := session.New(res, options)
+	ip := request.ClientIP(req)
+	sessions.Add(
+		session.Info{
+			ID:       id,
+			Stream:   req.URL.Path,
+			RemoteIP: ip,
+			Subject:  request.Subject(req),
+		},
+		client,
+	)
+	serveSession(req.Context(), id, reg, client)
+	client.Close()
+	sessions.Remove(id)
+	session.Log(id, req.URL.Path, ip, client.Summary())
+}
+
+// authHandler only passes on requests with valid basic auth credentials.
+func authHandler(users auth.Users, next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		username, ok := users.Check(req)
+		if !ok {
+			request.Log(req, generateID())
+			auth.Challenge(res)
+			return
+		}
+		next(res, request.WithSubject(req, username))
+	}
+}
+
+// adminHandler authenticates requests to admin endpoints with the given admin
+// credentials instead of the listener users and passes on all other requests.
+// Admin endpoints are not found on listeners without admins.
+func adminHandler(admins auth.Users, next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if !isAdminPath(req.URL.Path) {
+			next(res, req)
+			return
+		}
+		if len(admins) == 0 {
+			request.Log(req, generateID())
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		username, ok := admins.Check(req)
+		if !ok {
+			request.Log(req, generateID())
+			auth.Challenge(res)
+			return
+		}
+		req = request.WithSubject(req, username)
+		requestHandler(
+			res,
+			req.WithContext(context.WithValue(req.Context(), adminKey{}, true)),
+		)
+	}
+}
+
+// newServer creates the server and the network listeners for the given
+// listener configuration.
+func newServer(c config.Listener) (*http.Server, []net.Listener, error) {
+	var mode uint64
+	if c.SocketMode != "" {
+		var err error
+		mode, err = strconv.ParseUint(c.SocketMode, 8, 32)
+		if err != nil {
+			return nil, nil, errors.New("invalid socket mode: " + c.SocketMode)
+		}
+	}
+	handler := http.HandlerFunc(requestHandler)
+	if len(c.Users) > 0 {
+		handler = authHandler(c.Users, handler)
+	}
+	handler = adminHandler(c.Admins, handler)
+	server := &http.Server{Handler: handler}
+	if c.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	listeners, err := listener.Listen(c.Address, os.FileMode(mode))
+	if err != nil {
+		return nil, nil, err
+	}
+	return server, listeners, nil
+}
+
+// hasAdmins returns true if any of the given listeners has admins.
+func hasAdmins(configs []config.Listener) bool {
+	for _, c := range configs {
+		if len(c.Admins) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// serve handles requests for all given listener configurations until one of
+// the listeners fails.
+func serve(configs []config.Listener) {
+	errs := make(chan error)
+	for _, c := range configs {
+		server, listeners, err := newServer(c)
+		if err != nil {
+			log.Fatalln(c.Address+":", err)
+		}
+		for _, l := range listeners {
+			go func(server *http.Server, l net.Listener) {
+				if server.TLSConfig != nil {
+					errs <- server.ServeTLS(l, "", "")
+				} else {
+					errs <- server.Serve(l)
+				}
+			}(server, l)
+		}
+	}
+	log.Fatalln(<-errs)
+}
+
+// stringsFlag implements flag.Value for repeatable string options.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func stringsVar(name string, usage string) *stringsFlag {
+	s := &stringsFlag{}
+	flag.Var(s, name, usage)
+	return s
+}
+
+// readSecret returns the content of the given secret file without surrounding
+// whitespace.
+func readSecret(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content = []byte(strings.TrimSpace(string(content)))
+	if len(content) == 0 {
+		return nil, errors.New(path + ": empty secret")
+	}
+	return content, nil
+}
+
+// sign implements the sign subcommand, which writes a signed version of the
+// given stream URL to out.
+func sign(arguments []string, out io.Writer) error {
+	flags := flag.NewFlagSet("mjpeg-server sign", flag.ContinueOnError)
+	secretPath := flags.String("secret-file", "", "File with the shared secret")
+	ttl := flags.Duration("ttl", time.Hour, "Validity duration of the URL")
+	ip := flags.String("ip", "", "Client IP to restrict the URL to (optional)")
+	specs := &stringsFlag{}
+	flags.Var(specs, "param", "Query parameter of the server, repeatable")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: mjpeg-server sign [options] URL")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(arguments); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *secretPath == "" {
+		flags.Usage()
+		return errors.New("sign requires -secret-file and a URL")
+	}
+	key, err := readSecret(*secretPath)
+	if err != nil {
+		return err
+	}
+	commandParams, err := params.Parse(*specs)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	signature.SignURL(
+		key,
+		u,
+		streamParams(u.Query(), commandParams),
+		time.Now().Add(*ttl),
+		*ip,
+	)
+	fmt.Fprintln(out, u.String())
+	return nil
+}
+
+// readFrames returns the frames of the given multipart JPEG stream.
+// Frame times are taken from the X-Timestamp headers, if available.
+func readFrames(in io.Reader) ([]*frame.Frame, error) {
+	var frames []*frame.Frame
+	parser := frame.NewParser("", func(f *frame.Frame) {
+		value := f.Header.Get("X-Timestamp")
+		if timestamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			f.Time = timestamp
+		}
+		frames = append(frames, f)
+	})
+	_, err := io.Copy(parser, in)
+	return frames, err
+}
+
+// exportGIF implements the gif subcommand, which converts a multipart JPEG
+// stream from the given file or standard input into an animated GIF.
+func exportGIF(arguments []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("mjpeg-server gif", flag.ContinueOnError)
+	from := flags.String("from", "", "Start time or offset from the first frame")
+	to := flags.String("to", "", "End time or offset from the first frame")
+	fps := flags.Float64("fps", export.DefaultFPS, "Maximum frame rate")
+	width := flags.Int("width", 0, "Maximum width (unlimited if 0)")
+	colors := flags.Int("colors", export.DefaultColors, "Palette size (2-256)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: mjpeg-server gif [options] [FILE]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(arguments); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("gif accepts at most one input file")
+	}
+	options, err := gifOptions(url.Values{
+		"fps":    {strconv.FormatFloat(*fps, 'f', -1, 64)},
+		"width":  {strconv.Itoa(*width)},
+		"colors": {strconv.Itoa(*colors)},
+	})
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 1 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	frames, err := readFrames(in)
+	if err != nil {
+		return err
+	}
+	if len(frames) > 0 && (*from != "" || *to != "") {
+		first := frames[0].Time
+		start, end := first, frames[len(frames)-1].Time
+		if *from != "" {
+			if start, err = export.ParseTime(*from, first); err != nil {
+				return err
+			}
+		}
+		if *to != "" {
+			if end, err = export.ParseTime(*to, first); err != nil {
+				return err
+			}
+		}
+		var selected []*frame.Frame
+		for _, f := range frames {
+			if !f.Time.Before(start) && !f.Time.After(end) {
+				selected = append(selected, f)
+			}
+		}
+		frames = selected
+	}
+	return export.GIF(out, frames, options)
 }
 
 func parseArgs() {
@@ -72,11 +1198,178 @@ func parseArgs() {
 
 func main() {
 	log.SetOutput(os.Stderr)
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		if err := sign(os.Args[2:], os.Stdout); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gif" {
+		if err := exportGIF(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
 	parseArgs()
 	if *showVersion {
 		fmt.Println(Version)
 		os.Exit(0)
 	}
-	reg = registry.New(command, args, *directStart)
-	log.Fatalln(http.ListenAndServe(*addr, http.HandlerFunc(requestHandler)))
+	err := request.SetTrustedProxies(strings.Split(*trustedProxies, ","))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *secretFile != "" {
+		secret, err = readSecret(*secretFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if _, err := recording.ParseSignal(*stopSignal); err != nil {
+		log.Fatalln(err)
+	}
+	// Also rejects NaN, which fails all comparisons.
+	if !(*timeLapseFPS <= timelapse.MaxFPS) {
+		log.Fatalln("The -timelapse-fps option must not exceed", timelapse.MaxFPS)
+	}
+	if *jwtKeys != "" {
+		keys, err = jwt.Load(*jwtKeys)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	options := registry.Options{
+		Name:          *urlPath,
+		Command:       command,
+		Args:          args,
+		DirectStart:   *directStart,
+		Boundary:      *boundary,
+		InputBoundary: *inputBoundary,
+		Hooks: recording.Hooks{
+			PreStart:  *preStartHook,
+			PostStart: *postStartHook,
+			PostStop:  *postStopHook,
+			Timeout:   *hookTimeout,
+		},
+		StopSignal:   *stopSignal,
+		StopTimeout:  *stopTimeout,
+		StallTimeout: *stallTimeout,
+		Buffer:       *bufferDuration,
+	}
+	if *timeLapseInterval > 0 {
+		options.TimeLapse = &timelapse.Options{
+			Interval: *timeLapseInterval,
+			Frames:   *timeLapseFrames,
+			FPS:      *timeLapseFPS,
+		}
+	}
+	if *blackTimeout > 0 || *frozenTimeout > 0 {
+		options.Analysis = &analysis.Options{
+			Interval:      *analysisInterval,
+			BlackTimeout:  *blackTimeout,
+			FrozenTimeout: *frozenTimeout,
+		}
+	}
+	if *validationMode != "" {
+		options.Validation, err = validation.ParseMode(*validationMode)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *showPlaceholder {
+		options.Placeholder, err = placeholder.New(placeholder.Options{
+			Image:    *placeholderImage,
+			Status:   *placeholderStatus,
+			Interval: *placeholderInterval,
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	cfg := &config.Config{}
+	if *configFile != "" {
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	// Subscribe webhooks before creating the registry, which might start the
+	// recording directly.
+	for _, w := range cfg.Webhooks {
+		event.Subscribe(webhook.New(webhook.Options{
+			URL:    w.URL,
+			Secret: w.Secret,
+			Events: w.Events,
+		}).Handle)
+	}
+	specs := *maskSpecs
+	if s := cfg.Stream(*urlPath); s != nil {
+		specs = append(specs, s.Masks...)
+		for name, spec := range s.Crops {
+			crops[name], err = crop.Parse(spec)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+	initial, err := mask.Parse(specs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	masks.Set(initial)
+	options.Masks = masks
+	if len(*paramSpecs) > 0 {
+		queryParams, err = params.Parse(*paramSpecs)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if *directStart {
+			log.Fatalln("The -d option cannot be combined with -param options")
+		}
+		pool = registry.NewPool(options)
+	} else {
+		reg = registry.New(options)
+	}
+	for _, s := range cfg.Streams {
+		if s.Path != *urlPath {
+			log.Fatalln("Unknown stream path in configuration:", s.Path)
+		}
+	}
+	for _, m := range cfg.Mosaics {
+		if m.Path == *urlPath {
+			log.Fatalln("Mosaic path conflicts with the stream path:", m.Path)
+		}
+		mosaics[m.Path], err = newMosaic(m)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	limiter = limit.New(*maxClients, *maxClientsPerIP)
+	if s := cfg.Stream(*urlPath); s != nil {
+		rules, err = access.Parse(s.Access)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		limiter.SetStreamLimit(*urlPath, s.MaxClients)
+		maxKbps = s.MaxKbps
+	}
+	if len(*addrs) == 0 && len(cfg.Listeners) == 0 {
+		addrs.Set(":9000")
+	}
+	for _, address := range *addrs {
+		cfg.Listeners = append(cfg.Listeners, config.Listener{
+			Address:    address,
+			SocketMode: *socketMode,
+		})
+	}
+	if (*maskPath != "" || *clientsPath != "") && !hasAdmins(cfg.Listeners) {
+		log.Fatalln("Admin endpoints require a listener with Admins")
+	}
+	serve(cfg.Listeners)
 }
@@ -1,17 +1,53 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/blueimp/mjpeg-server/internal/access"
+	"github.com/blueimp/mjpeg-server/internal/auth"
+	"github.com/blueimp/mjpeg-server/internal/config"
+	"github.com/blueimp/mjpeg-server/internal/event"
+	"github.com/blueimp/mjpeg-server/internal/frame"
+	"github.com/blueimp/mjpeg-server/internal/jwt"
+	"github.com/blueimp/mjpeg-server/internal/limit"
+	"github.com/blueimp/mjpeg-server/internal/params"
 	"github.com/blueimp/mjpeg-server/internal/registry"
+	"github.com/blueimp/mjpeg-server/internal/session"
+	"github.com/blueimp/mjpeg-server/internal/signature"
+	"github.com/blueimp/mjpeg-server/internal/timelapse"
 )
 
 func TestRequestHandler(t *testing.T) {
-	reg = registry.New(command, args, false)
+	reg = registry.New(registry.Options{Command: command, Args: args})
 	rec := httptest.NewRecorder()
 	ctx, cancel := context.WithCancel(context.Background())
 	req := httptest.NewRequest(
@@ -61,7 +97,7 @@ func TestRequestHandler(t *testing.T) {
 }
 
 func TestRequestHandlerWithInvalidMethod(t *testing.T) {
-	reg = registry.New(command, args, false)
+	reg = registry.New(registry.Options{Command: command, Args: args})
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(
 		"POST",
@@ -79,7 +115,7 @@ func TestRequestHandlerWithInvalidMethod(t *testing.T) {
 }
 
 func TestRequestHandlerWithInvalidPath(t *testing.T) {
-	reg = registry.New(command, args, false)
+	reg = registry.New(registry.Options{Command: command, Args: args})
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(
 		"GET",
@@ -94,10 +130,21 @@ func TestRequestHandlerWithInvalidPath(t *testing.T) {
 			http.StatusNotFound,
 		)
 	}
+	// The single frame endpoint is disabled by default.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "http://localhost:9000/frame", nil)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusNotFound,
+		)
+	}
 }
 
 func TestRequestHandlerWithCustomPath(t *testing.T) {
-	reg = registry.New(command, args, false)
+	reg = registry.New(registry.Options{Command: command, Args: args})
 	*urlPath = "/banana"
 	rec := httptest.NewRecorder()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -136,7 +183,7 @@ func TestRequestHandlerWithCustomPath(t *testing.T) {
 }
 
 func TestRequestHandlerWithCustomBoundary(t *testing.T) {
-	reg = registry.New(command, args, false)
+	reg = registry.New(registry.Options{Command: command, Args: args})
 	*boundary = "banana"
 	rec := httptest.NewRecorder()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -161,3 +208,1433 @@ func TestRequestHandlerWithCustomBoundary(t *testing.T) {
 	}
 	*boundary = "ffmpeg"
 }
+
+func TestRequestHandlerWithMetricsPath(t *testing.T) {
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	*metricsPath = "/metrics"
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/metrics",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	header := rec.Header().Get("Content-Type")
+	expectedHeader := "text/plain; version=0.0.4"
+	if header != expectedHeader {
+		t.Errorf(
+			"Unexpected Content-Type header: %s. Expected: %s",
+			header,
+			expectedHeader,
+		)
+	}
+	*metricsPath = ""
+	rec = httptest.NewRecorder()
+	requestHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusNotFound,
+		)
+	}
+}
+
+func TestRequestHandlerWithReadyPath(t *testing.T) {
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	*readyPath = "/ready"
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/ready",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	var result readiness
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if !result.Ready {
+		t.Error("Unexpected readiness: false")
+	}
+	if _, ok := result.Streams["/"]; !ok {
+		t.Errorf("Unexpected streams: %v", result.Streams)
+	}
+	*readyPath = ""
+}
+
+func TestRequestHandlerWithFramePath(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{
+		Command:  "go",
+		Args:     []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary: "ffmpeg",
+	})
+	imageData, _ := ioutil.ReadFile("gopher.jpg")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?timeout=30s",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	header := rec.Header().Get("Content-Type")
+	expectedHeader := "image/jpeg"
+	if header != expectedHeader {
+		t.Errorf(
+			"Unexpected Content-Type header: %s. Expected: %s",
+			header,
+			expectedHeader,
+		)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), imageData) {
+		t.Error("Unexpected frame data")
+	}
+	sequence, _ := strconv.ParseUint(rec.Header().Get("X-Frame-Sequence"), 10, 64)
+	timestamp, err := time.Parse(
+		time.RFC3339Nano,
+		rec.Header().Get("X-Frame-Timestamp"),
+	)
+	if err != nil {
+		t.Errorf("Unexpected X-Frame-Timestamp header: %s", err)
+	}
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?timeout=30s&after="+
+			strconv.FormatUint(sequence, 10),
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	nextSequence, _ := strconv.ParseUint(
+		rec.Header().Get("X-Frame-Sequence"),
+		10,
+		64,
+	)
+	if nextSequence <= sequence {
+		t.Errorf(
+			"Unexpected X-Frame-Sequence header: %d. Expected: > %d",
+			nextSequence,
+			sequence,
+		)
+	}
+	nextTimestamp, _ := time.Parse(
+		time.RFC3339Nano,
+		rec.Header().Get("X-Frame-Timestamp"),
+	)
+	if !nextTimestamp.After(timestamp) {
+		t.Errorf(
+			"Unexpected X-Frame-Timestamp header: %s. Expected: > %s",
+			nextTimestamp,
+			timestamp,
+		)
+	}
+}
+
+func TestRequestHandlerWithFrameTimeout(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?timeout=100ms&after=2020-05-01T12:00:00Z",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusGatewayTimeout,
+		)
+	}
+	for _, query := range []string{"after=banana", "timeout=banana"} {
+		rec = httptest.NewRecorder()
+		req = httptest.NewRequest(
+			"GET",
+			"http://localhost:9000/frame?"+query,
+			nil,
+		)
+		requestHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf(
+				"Unexpected response status: %d. Expected: %d",
+				rec.Code,
+				http.StatusBadRequest,
+			)
+		}
+	}
+}
+
+func TestRequestHandlerWithDetectedBoundary(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{
+		Command: "go",
+		Args: []string{
+			"run",
+			"mpjpeg/main.go",
+			"-b",
+			"banana",
+			"gopher.jpg",
+		},
+		Boundary:      "ffmpeg",
+		InputBoundary: "ffmpeg",
+	})
+	imageData, _ := ioutil.ReadFile("gopher.jpg")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?timeout=30s",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), imageData) {
+		t.Error("Unexpected frame data")
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	handler := authHandler(auth.Users{"banana": "apple"}, requestHandler)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/invalid",
+		nil,
+	)
+	handler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusUnauthorized,
+		)
+	}
+	rec = httptest.NewRecorder()
+	req.SetBasicAuth("banana", "apple")
+	handler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusNotFound,
+		)
+	}
+}
+
+func writeCertificateHelper(dir string) (certPath string, keyPath string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(
+		rand.Reader,
+		template,
+		template,
+		&key.PublicKey,
+		key,
+	)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(
+		certPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600,
+	)
+	ioutil.WriteFile(
+		keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		0600,
+	)
+	return
+}
+
+func TestNewServer(t *testing.T) {
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	*readyPath = "/ready"
+	defer func() { *readyPath = "" }()
+	tmpDir, _ := ioutil.TempDir("", "mjpeg-server")
+	defer os.RemoveAll(tmpDir)
+	certPath, keyPath := writeCertificateHelper(tmpDir)
+	server, listeners, err := newServer(config.Listener{
+		Address: "127.0.0.1:0",
+		TLSCert: certPath,
+		TLSKey:  keyPath,
+		Users:   auth.Users{"banana": "apple"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer server.Close()
+	go server.ServeTLS(listeners[0], "", "")
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	url := "https://" + listeners[0].Addr().String() + "/ready"
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			res.StatusCode,
+			http.StatusUnauthorized,
+		)
+	}
+	req, _ := http.NewRequest("GET", url, nil)
+	req.SetBasicAuth("banana", "apple")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			res.StatusCode,
+			http.StatusOK,
+		)
+	}
+	_, _, err = newServer(config.Listener{
+		Address:    "127.0.0.1:0",
+		SocketMode: "banana",
+	})
+	if err == nil {
+		t.Error("Unexpected nil error for invalid socket mode")
+	}
+}
+
+func TestRequestHandlerWithAccessRules(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	rules, _ = access.Parse([]string{"allow 10.0.0.0/8", "deny all"})
+	defer func() { rules = nil }()
+	for _, path := range []string{"/", "/frame"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:9000"+path, nil)
+		requestHandler(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf(
+				"Unexpected response status: %d. Expected: %d",
+				rec.Code,
+				http.StatusForbidden,
+			)
+		}
+	}
+	if clients := reg.Status().Clients; clients != 0 {
+		t.Errorf("Unexpected clients: %d. Expected: %d", clients, 0)
+	}
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/",
+		nil,
+	).WithContext(ctx)
+	req.RemoteAddr = "10.0.0.1:1234"
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+}
+
+func TestRequestHandlerWithClientLimits(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	limiter = limit.New(1, 0)
+	*gifPath = "/gif"
+	defer func() {
+		limiter = limit.New(0, 0)
+		*gifPath = ""
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/",
+		nil,
+	).WithContext(ctx)
+	done := make(chan bool)
+	go func() {
+		requestHandler(httptest.NewRecorder(), req)
+		done <- true
+	}()
+	time.Sleep(100 * time.Millisecond)
+	for _, path := range []string{"/", "/frame", "/gif"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:9000"+path, nil)
+		requestHandler(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf(
+				"Unexpected response status: %d. Expected: %d",
+				rec.Code,
+				http.StatusServiceUnavailable,
+			)
+		}
+		if header := rec.Header().Get("Retry-After"); header != retryAfter {
+			t.Errorf(
+				"Unexpected Retry-After header: %s. Expected: %s",
+				header,
+				retryAfter,
+			)
+		}
+	}
+	cancel()
+	<-done
+	rec := httptest.NewRecorder()
+	req = httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?timeout=0s",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code == http.StatusServiceUnavailable {
+		t.Error("Unexpected rejection after the client disconnected")
+	}
+}
+
+func TestSign(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "sign")
+	defer os.RemoveAll(tmpDir)
+	secretPath := filepath.Join(tmpDir, "secret")
+	ioutil.WriteFile(secretPath, []byte("banana\n"), 0600)
+	var out bytes.Buffer
+	err := sign(
+		[]string{"-secret-file", secretPath, "-ip", "192.0.2.1", "http://a/b"},
+		&out,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	u, err := url.Parse(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = signature.Verify([]byte("banana"), u, nil, "192.0.2.1", time.Now())
+	if err != nil {
+		t.Errorf("Unexpected error for %s: %s", u, err)
+	}
+	for _, arguments := range [][]string{
+		{"http://a/b"},
+		{"-secret-file", secretPath},
+		{"-secret-file", filepath.Join(tmpDir, "missing"), "http://a/b"},
+		{"-secret-file", secretPath, "-param", "display", "http://a/b"},
+	} {
+		if err := sign(arguments, &out); err == nil {
+			t.Errorf("Unexpected nil error for arguments: %v", arguments)
+		}
+	}
+}
+
+func TestSignWithCheckAccess(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "sign")
+	defer os.RemoveAll(tmpDir)
+	secretPath := filepath.Join(tmpDir, "secret")
+	ioutil.WriteFile(secretPath, []byte("banana\n"), 0600)
+	queryParams, _ = params.Parse([]string{`display=:[0-9]+`})
+	pool = registry.NewPool(registry.Options{Name: "/", Command: "go"})
+	secret = []byte("banana")
+	defer func() {
+		queryParams = nil
+		pool = nil
+		secret = nil
+	}()
+	for _, target := range []string{
+		"http://localhost:9000/?maxkbps=100",
+		"http://localhost:9000/frame?timeout=5s",
+		"http://localhost:9000/?display=:3&crop=door&maxkbps=100",
+	} {
+		var out bytes.Buffer
+		err := sign(
+			[]string{
+				"-secret-file", secretPath,
+				"-param", `display=:[0-9]+`,
+				target,
+			},
+			&out,
+		)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		signed := strings.TrimSpace(out.String())
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", signed, nil)
+		if !checkAccess(rec, req, "1") {
+			t.Errorf(
+				"Unexpected response status for %s: %d. Expected: %d",
+				signed,
+				rec.Code,
+				http.StatusOK,
+			)
+		}
+	}
+}
+
+func TestRequestHandlerWithSignedURLs(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	secret = []byte("banana")
+	defer func() { secret = nil }()
+	expired, _ := url.Parse("http://localhost:9000/")
+	signature.SignURL(secret, expired, nil, time.Now().Add(-time.Second), "")
+	tampered, _ := url.Parse("http://localhost:9000/")
+	signature.SignURL(secret, tampered, nil, time.Now().Add(time.Hour), "")
+	tampered.Path = "/frame"
+	for _, target := range []string{
+		"http://localhost:9000/",
+		expired.String(),
+		tampered.String(),
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", target, nil)
+		requestHandler(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf(
+				"Unexpected response status for %s: %d. Expected: %d",
+				target,
+				rec.Code,
+				http.StatusForbidden,
+			)
+		}
+	}
+	signed, _ := url.Parse("http://localhost:9000/")
+	signature.SignURL(secret, signed, nil, time.Now().Add(time.Hour), "")
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", signed.String(), nil).WithContext(ctx)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+}
+
+func tokenHelper(key []byte, claims *jwt.Claims) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": jwt.HS256})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + encode(mac.Sum(nil))
+}
+
+func TestRequestHandlerWithJWT(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	tmpDir, _ := ioutil.TempDir("", "jwt")
+	defer os.RemoveAll(tmpDir)
+	keysPath := filepath.Join(tmpDir, "jwks.json")
+	ioutil.WriteFile(
+		keysPath,
+		[]byte(`{"keys": [{"kty": "oct", "k": "YmFuYW5h"}]}`),
+		0600,
+	)
+	keys, _ = jwt.Load(keysPath)
+	defer func() { keys = nil }()
+	expires := time.Now().Add(time.Hour).Unix()
+	valid := tokenHelper([]byte("banana"), &jwt.Claims{
+		Subject: "dashboard",
+		Expires: expires,
+		Streams: []string{"/"},
+	})
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{tokenHelper([]byte("apple"), &jwt.Claims{}), http.StatusUnauthorized},
+		{
+			tokenHelper([]byte("banana"), &jwt.Claims{Streams: []string{"/a"}}),
+			http.StatusForbidden,
+		},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:9000/frame", nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		requestHandler(rec, req)
+		if rec.Code != test.status {
+			t.Errorf(
+				"Unexpected response status: %d. Expected: %d",
+				rec.Code,
+				test.status,
+			)
+		}
+	}
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/?access_token="+valid,
+		nil,
+	).WithContext(ctx)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	result := authenticate(req).Context().Value(tokenKey{}).(*token)
+	if result.err != nil || result.claims.Subject != "dashboard" {
+		t.Errorf("Unexpected token verification: %+v", result)
+	}
+}
+
+func TestRequestHandlerWithParams(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	originalArgs := args
+	args = []string{"run", "mpjpeg/main.go", "{image}"}
+	queryParams, _ = params.Parse([]string{`image=gopher\.jpg`})
+	pool = registry.NewPool(registry.Options{
+		Name:     "/",
+		Command:  "go",
+		Boundary: "ffmpeg",
+	})
+	reg = nil
+	defer func() {
+		args = originalArgs
+		queryParams = nil
+		pool = nil
+	}()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?image=mpjpeg/main.go",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusBadRequest,
+		)
+	}
+	imageData, _ := ioutil.ReadFile("gopher.jpg")
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?image=gopher.jpg&timeout=30s",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), imageData) {
+		t.Error("Unexpected frame data")
+	}
+	if status := pool.Status(); len(status) != 0 {
+		t.Errorf("Unexpected registries after release: %v", status)
+	}
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "http://localhost:9000/ready", nil)
+	*readyPath = "/ready"
+	defer func() { *readyPath = "" }()
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+}
+
+func TestCheckAccessWithParams(t *testing.T) {
+	queryParams, _ = params.Parse([]string{`display=:[0-9]+`})
+	pool = registry.NewPool(registry.Options{Name: "/", Command: "go"})
+	secret = []byte("banana")
+	defer func() {
+		queryParams = nil
+		pool = nil
+		secret = nil
+	}()
+	signed, _ := url.Parse("http://localhost:9000/?display=:3&crop=door")
+	signature.SignURL(
+		secret,
+		signed,
+		streamParams(signed.Query(), queryParams),
+		time.Now().Add(time.Hour),
+		"",
+	)
+	tests := []struct {
+		target  string
+		allowed bool
+	}{
+		{signed.String(), true},
+		{signed.String() + "&timeout=1s", true},
+		{strings.Replace(signed.String(), "%3A3", "%3A4", 1), false},
+		{strings.Replace(signed.String(), "crop=door", "crop=all", 1), false},
+		{strings.Replace(signed.String(), "crop=door&", "", 1), false},
+		{signed.String() + "&timelapse", false},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.target, nil)
+		if allowed := checkAccess(rec, req, "1"); allowed != test.allowed {
+			t.Errorf(
+				"Unexpected access for %s: %t. Expected: %t",
+				test.target,
+				allowed,
+				test.allowed,
+			)
+		}
+	}
+	secret = nil
+	tmpDir, _ := ioutil.TempDir("", "jwt")
+	defer os.RemoveAll(tmpDir)
+	keysPath := filepath.Join(tmpDir, "jwks.json")
+	ioutil.WriteFile(
+		keysPath,
+		[]byte(`{"keys": [{"kty": "oct", "k": "YmFuYW5h"}]}`),
+		0600,
+	)
+	keys, _ = jwt.Load(keysPath)
+	defer func() { keys = nil }()
+	token := tokenHelper([]byte("banana"), &jwt.Claims{
+		Expires: time.Now().Add(time.Hour).Unix(),
+		Streams: []string{"/?display=%3A3"},
+	})
+	for _, test := range []struct {
+		target  string
+		allowed bool
+	}{
+		{"http://localhost:9000/frame?display=:3&after=1", true},
+		{"http://localhost:9000/frame?display=:4", false},
+		{"http://localhost:9000/frame?display=:3&crop=door", false},
+		{"http://localhost:9000/frame", false},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		allowed := checkAccess(rec, authenticate(req), "1")
+		if allowed != test.allowed {
+			t.Errorf(
+				"Unexpected access for %s: %t. Expected: %t",
+				test.target,
+				allowed,
+				test.allowed,
+			)
+		}
+	}
+}
+
+func TestRequestHandlerWithMasks(t *testing.T) {
+	*maskPath = "/masks"
+	defer func() {
+		*maskPath = ""
+		masks.Set(nil)
+	}()
+	for _, test := range []struct {
+		handler http.HandlerFunc
+		code    int
+	}{
+		{requestHandler, http.StatusNotFound},
+		{
+			authHandler(auth.Users{"dashboard": "apple"}, requestHandler),
+			http.StatusNotFound,
+		},
+		{adminHandler(nil, requestHandler), http.StatusNotFound},
+		{
+			adminHandler(auth.Users{"admin": "banana"}, requestHandler),
+			http.StatusUnauthorized,
+		},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(
+			"PUT",
+			"http://localhost:9000/masks",
+			strings.NewReader("[]"),
+		)
+		req.SetBasicAuth("dashboard", "apple")
+		test.handler(rec, req)
+		if rec.Code != test.code {
+			t.Errorf(
+				"Unexpected response status for non-admin: %d. Expected: %d",
+				rec.Code,
+				test.code,
+			)
+		}
+	}
+	handler := adminHandler(auth.Users{"admin": "banana"}, requestHandler)
+	changes := make(chan event.Event, 1)
+	event.Subscribe(func(e event.Event) {
+		if e.Event == "masks-change" {
+			select {
+			case changes <- e:
+			default:
+			}
+		}
+	})
+	for _, test := range []struct {
+		method string
+		body   string
+		code   int
+		masks  string
+	}{
+		{"GET", "", http.StatusOK, "[]"},
+		{"POST", "", http.StatusMethodNotAllowed, ""},
+		{"PUT", "banana", http.StatusBadRequest, ""},
+		{"PUT", `["0,0,banana"]`, http.StatusBadRequest, ""},
+		{"PUT", `["0,0,100%,10%,pixelate"]`, http.StatusOK, ""},
+		{"GET", "", http.StatusOK, `["0,0,100%,10%,pixelate"]`},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(
+			test.method,
+			"http://localhost:9000/masks",
+			strings.NewReader(test.body),
+		)
+		req.SetBasicAuth("admin", "banana")
+		handler(rec, req)
+		if rec.Code != test.code {
+			t.Errorf(
+				"Unexpected response status for %s %s: %d. Expected: %d",
+				test.method,
+				test.body,
+				rec.Code,
+				test.code,
+			)
+		}
+		body := strings.TrimSpace(rec.Body.String())
+		if test.masks != "" && body != test.masks {
+			t.Errorf("Unexpected masks: %s. Expected: %s", body, test.masks)
+		}
+	}
+	select {
+	case e := <-changes:
+		specs, _ := e.Data["Masks"].([]string)
+		if len(specs) != 1 || e.Data["RemoteIP"] != "192.0.2.1" ||
+			e.Data["Subject"] != "admin" {
+			t.Errorf("Unexpected masks-change event: %v", e)
+		}
+	default:
+		t.Error("Unexpected: no masks-change event")
+	}
+}
+
+func TestRequestHandlerWithCrop(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{
+		Command:  "go",
+		Args:     []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary: "ffmpeg",
+	})
+	crops["corner"] = image.Rect(0, 0, 20, 10)
+	defer delete(crops, "corner")
+	for _, test := range []struct {
+		crop   string
+		code   int
+		width  int
+		height int
+	}{
+		{"banana", http.StatusBadRequest, 0, 0},
+		{"0,0,banana", http.StatusBadRequest, 0, 0},
+		{"corner", http.StatusOK, 20, 10},
+		{"10,20,30,40", http.StatusOK, 30, 40},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(
+			"GET",
+			"http://localhost:9000/frame?timeout=30s&crop="+test.crop,
+			nil,
+		)
+		requestHandler(rec, req)
+		if rec.Code != test.code {
+			t.Errorf(
+				"Unexpected response status for crop %s: %d. Expected: %d",
+				test.crop,
+				rec.Code,
+				test.code,
+			)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		size, err := jpeg.DecodeConfig(rec.Body)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if size.Width != test.width || size.Height != test.height {
+			t.Errorf(
+				"Unexpected size: %dx%d. Expected: %dx%d",
+				size.Width,
+				size.Height,
+				test.width,
+				test.height,
+			)
+		}
+	}
+}
+
+func TestRequestHandlerWithGIFPath(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{
+		Command:  "go",
+		Args:     []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary: "ffmpeg",
+		Buffer:   time.Minute,
+	})
+	*gifPath = "/gif"
+	defer func() { *gifPath = "" }()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/frame?timeout=30s",
+		nil,
+	)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf(
+			"Unexpected frame response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusOK,
+		)
+	}
+	for _, test := range []struct {
+		query string
+		code  int
+	}{
+		{"fps=banana", http.StatusBadRequest},
+		{"fps=51", http.StatusBadRequest},
+		{"colors=1", http.StatusBadRequest},
+		{"from=banana", http.StatusBadRequest},
+		{"from=1h", http.StatusNotFound},
+		{"width=20&colors=16", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(
+			"GET",
+			"http://localhost:9000/gif?"+test.query,
+			nil,
+		)
+		requestHandler(rec, req)
+		if rec.Code != test.code {
+			t.Errorf(
+				"Unexpected response status for %s: %d. Expected: %d",
+				test.query,
+				rec.Code,
+				test.code,
+			)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		contentType := rec.Header().Get("Content-Type")
+		if contentType != "image/gif" {
+			t.Errorf(
+				"Unexpected Content-Type: %s. Expected: %s",
+				contentType,
+				"image/gif",
+			)
+		}
+		animation, err := gif.DecodeAll(rec.Body)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		width := animation.Config.Width
+		if width != 20 {
+			t.Errorf("Unexpected width: %d. Expected: %d", width, 20)
+		}
+	}
+}
+
+func TestExportGIF(t *testing.T) {
+	imageData, _ := ioutil.ReadFile("gopher.jpg")
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var stream bytes.Buffer
+	for i := 0; i < 10; i++ {
+		f := &frame.Frame{
+			Data:   imageData,
+			Header: textproto.MIMEHeader{},
+			Time:   start.Add(time.Duration(i) * 100 * time.Millisecond),
+		}
+		stream.Write(f.Encode("ffmpeg"))
+	}
+	stream.WriteString("--ffmpeg--\r\n")
+	for _, test := range []struct {
+		arguments []string
+		frames    int
+	}{
+		{[]string{"-fps", "5"}, 5},
+		{[]string{"-fps", "10", "-from", "500ms"}, 5},
+		{[]string{"-fps", "10", "-to", "2020-01-01T00:00:00.2Z"}, 3},
+	} {
+		var out bytes.Buffer
+		in := bytes.NewReader(stream.Bytes())
+		if err := exportGIF(test.arguments, in, &out); err != nil {
+			t.Fatalf("Unexpected error for %v: %s", test.arguments, err)
+		}
+		animation, err := gif.DecodeAll(&out)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %s", test.arguments, err)
+		}
+		if len(animation.Image) != test.frames {
+			t.Errorf(
+				"Unexpected frame count for %v: %d. Expected: %d",
+				test.arguments,
+				len(animation.Image),
+				test.frames,
+			)
+		}
+	}
+	for _, arguments := range [][]string{
+		{"-colors", "1"},
+		{"-from", "banana"},
+		{"-from", "1h"},
+		{"a", "b"},
+	} {
+		in := bytes.NewReader(stream.Bytes())
+		if err := exportGIF(arguments, in, ioutil.Discard); err == nil {
+			t.Errorf("Unexpected nil error for arguments: %v", arguments)
+		}
+	}
+}
+
+func TestRequestHandlerWithTimeLapse(t *testing.T) {
+	*framePath = "/frame"
+	defer func() { *framePath = "" }()
+	reg = registry.New(registry.Options{
+		Command:   "go",
+		Args:      []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary:  "ffmpeg",
+		TimeLapse: &timelapse.Options{Interval: time.Second},
+	})
+	imageData, _ := ioutil.ReadFile("gopher.jpg")
+	for _, test := range []struct {
+		interval time.Duration
+		query    string
+		code     int
+	}{
+		{0, "timelapse", http.StatusBadRequest},
+		{time.Second, "timelapse&crop=0,0,10,10", http.StatusBadRequest},
+		{time.Second, "timelapse", http.StatusOK},
+	} {
+		*timeLapseInterval = test.interval
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(
+			"GET",
+			"http://localhost:9000/frame?timeout=30s&"+test.query,
+			nil,
+		)
+		requestHandler(rec, req)
+		if rec.Code != test.code {
+			t.Errorf(
+				"Unexpected response status for %s: %d. Expected: %d",
+				test.query,
+				rec.Code,
+				test.code,
+			)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		if !bytes.Equal(rec.Body.Bytes(), imageData) {
+			t.Errorf("Unexpected response body for %s", test.query)
+		}
+	}
+	*timeLapseInterval = 0
+}
+
+func TestRequestHandlerWithMosaic(t *testing.T) {
+	reg = registry.New(registry.Options{
+		Command:  "go",
+		Args:     []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary: "ffmpeg",
+	})
+	grid, err := newMosaic(config.Mosaic{
+		Path:    "/grid",
+		Sources: []string{"", "crop=0,0,20,10"},
+		Width:   320,
+		FPS:     10,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	mosaics["/grid"] = grid
+	defer delete(mosaics, "/grid")
+	_, err = newMosaic(config.Mosaic{Path: "/grid", Sources: []string{"crop=a"}})
+	if err == nil {
+		t.Error("Unexpected nil error for invalid source")
+	}
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/grid",
+		nil,
+	).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		requestHandler(rec, req)
+		close(done)
+	}()
+	frameCtx, frameCancel := context.WithTimeout(
+		context.Background(),
+		30*time.Second,
+	)
+	defer frameCancel()
+	f := grid.Frame(frameCtx, func(f *frame.Frame) bool { return true })
+	if num := reg.Status().Clients; num != 2 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 2)
+	}
+	cancel()
+	<-done
+	if f == nil {
+		t.Fatal("Unexpected: no mosaic frame")
+	}
+	size, err := jpeg.DecodeConfig(bytes.NewReader(f.Data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// Two sources are arranged in two columns of 160x90 tiles.
+	if size.Width != 320 || size.Height != 90 {
+		t.Errorf(
+			"Unexpected size: %dx%d. Expected: %dx%d",
+			size.Width,
+			size.Height,
+			320,
+			90,
+		)
+	}
+	if num := reg.Status().Clients; num != 0 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 0)
+	}
+}
+
+func TestCheckAccessWithMosaic(t *testing.T) {
+	mosaics["/grid"] = registry.New(registry.Options{Name: "/grid"})
+	defer delete(mosaics, "/grid")
+	tmpDir, _ := ioutil.TempDir("", "jwt")
+	defer os.RemoveAll(tmpDir)
+	keysPath := filepath.Join(tmpDir, "jwks.json")
+	ioutil.WriteFile(
+		keysPath,
+		[]byte(`{"keys": [{"kty": "oct", "k": "YmFuYW5h"}]}`),
+		0600,
+	)
+	keys, _ = jwt.Load(keysPath)
+	defer func() { keys = nil }()
+	expires := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		path    string
+		streams []string
+		allowed bool
+	}{
+		{"/grid", []string{"/grid"}, true},
+		{"/grid", []string{"/"}, false},
+		{"/", []string{"/grid"}, false},
+		{"/", []string{"/"}, true},
+	}
+	for _, test := range tests {
+		token := tokenHelper([]byte("banana"), &jwt.Claims{
+			Expires: expires,
+			Streams: test.streams,
+		})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:9000"+test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		allowed := checkAccess(rec, authenticate(req), "1")
+		if allowed != test.allowed {
+			t.Errorf(
+				"Unexpected access to %s for %v: %t. Expected: %t",
+				test.path,
+				test.streams,
+				allowed,
+				test.allowed,
+			)
+		}
+	}
+}
+
+func TestClientKbps(t *testing.T) {
+	maxKbps = 512
+	defer func() { maxKbps = 0 }()
+	for value, expected := range map[string]int{
+		"":     512,
+		"0":    512,
+		"256":  256,
+		"512":  512,
+		"1024": 512,
+	} {
+		kbps, err := clientKbps(value)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", value, err)
+		}
+		if kbps != expected {
+			t.Errorf(
+				"Unexpected kbps for %q: %d. Expected: %d",
+				value,
+				kbps,
+				expected,
+			)
+		}
+	}
+	for _, value := range []string{"-1", "banana"} {
+		if _, err := clientKbps(value); err == nil {
+			t.Errorf("Unexpected nil error for %q", value)
+		}
+	}
+	maxKbps = 0
+	if kbps, _ := clientKbps("1024"); kbps != 1024 {
+		t.Errorf("Unexpected kbps: %d. Expected: %d", kbps, 1024)
+	}
+}
+
+func TestRequestHandlerWithMaxKbps(t *testing.T) {
+	reg = registry.New(registry.Options{
+		Command:  "go",
+		Args:     []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary: "ffmpeg",
+	})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:9000/?maxkbps=-1", nil)
+	requestHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf(
+			"Unexpected response status: %d. Expected: %d",
+			rec.Code,
+			http.StatusBadRequest,
+		)
+	}
+	// A frame of the test image takes several seconds at 1 kbps.
+	rec = httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req = httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/?maxkbps=1",
+		nil,
+	).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		requestHandler(rec, req)
+		close(done)
+	}()
+	frameCtx, frameCancel := context.WithTimeout(
+		context.Background(),
+		30*time.Second,
+	)
+	defer frameCancel()
+	reg.Frame(frameCtx, func(f *frame.Frame) bool { return f.Sequence >= 3 })
+	cancel()
+	<-done
+	var frames int
+	parser := frame.NewParser("ffmpeg", func(f *frame.Frame) { frames++ })
+	parser.Write(rec.Body.Bytes())
+	if frames != 1 {
+		t.Errorf("Unexpected number of frames: %d. Expected: %d", frames, 1)
+	}
+}
+
+// sessionHelper records the written frames and provides the quality levels
+// sent by the test.
+type sessionHelper struct {
+	levels chan int
+	frames chan *frame.Frame
+	parser io.Writer
+}
+
+func newSessionHelper() *sessionHelper {
+	s := &sessionHelper{make(chan int), make(chan *frame.Frame, 100), nil}
+	s.parser = frame.NewParser("ffmpeg", func(f *frame.Frame) {
+		select {
+		case s.frames <- f:
+		default:
+		}
+	})
+	return s
+}
+
+func (s *sessionHelper) Write(p []byte) (int, error) {
+	return s.parser.Write(p)
+}
+
+func (s *sessionHelper) Summary() session.Summary {
+	return session.Summary{}
+}
+
+func (s *sessionHelper) Levels() <-chan int {
+	return s.levels
+}
+
+func (s *sessionHelper) Close() {}
+
+func TestServeSession(t *testing.T) {
+	source := registry.New(registry.Options{
+		Command:  "go",
+		Args:     []string{"run", "mpjpeg/main.go", "gopher.jpg"},
+		Boundary: "ffmpeg",
+	})
+	imageData, _ := ioutil.ReadFile("gopher.jpg")
+	original, _ := jpeg.DecodeConfig(bytes.NewReader(imageData))
+	client := newSessionHelper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveSession(ctx, "1", source, client)
+		close(done)
+	}()
+	for _, level := range []int{1, 3, 0} {
+		client.levels <- level
+		// Skip frames which were sent before the level change.
+		timeout := time.After(30 * time.Second)
+		width := original.Width * []int{100, 75, 50, 25}[level] / 100
+		for found := false; !found; {
+			select {
+			case f := <-client.frames:
+				size, err := jpeg.DecodeConfig(bytes.NewReader(f.Data))
+				found = err == nil && size.Width == width
+			case <-timeout:
+				t.Fatalf("Unexpected: no frame for level %d", level)
+			}
+		}
+	}
+	cancel()
+	<-done
+	if num := source.Status().Clients; num != 0 {
+		t.Errorf("Unexpected source clients: %d. Expected: %d", num, 0)
+	}
+}
+
+func TestRequestHandlerWithClientsPath(t *testing.T) {
+	reg = registry.New(registry.Options{Command: command, Args: args})
+	*clientsPath = "/clients"
+	*adaptiveQuality = true
+	defer func() {
+		*clientsPath = ""
+		*adaptiveQuality = false
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(
+		"GET",
+		"http://localhost:9000/?maxkbps=512",
+		nil,
+	).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		requestHandler(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	handler := adminHandler(auth.Users{"admin": "banana"}, requestHandler)
+	var status []session.Status
+	for i := 0; i < 100 && len(status) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:9000/clients", nil)
+		req.SetBasicAuth("admin", "banana")
+		handler(rec, req)
+		json.NewDecoder(rec.Body).Decode(&status)
+	}
+	for _, test := range []struct {
+		handler http.HandlerFunc
+		code    int
+	}{
+		{requestHandler, http.StatusNotFound},
+		{handler, http.StatusUnauthorized},
+	} {
+		rec := httptest.NewRecorder()
+		test.handler(
+			rec,
+			httptest.NewRequest("GET", "http://localhost:9000/clients", nil),
+		)
+		if rec.Code != test.code {
+			t.Errorf(
+				"Unexpected response status for non-admin: %d. Expected: %d",
+				rec.Code,
+				test.code,
+			)
+		}
+	}
+	cancel()
+	<-done
+	if len(status) != 1 {
+		t.Fatalf("Unexpected clients: %d. Expected: %d", len(status), 1)
+	}
+	if status[0].Stream != "/" || status[0].MaxKbps != 512 {
+		t.Errorf("Unexpected client status: %+v", status[0])
+	}
+	if len(sessions.Status()) != 0 {
+		t.Error("Unexpected: session not removed")
+	}
+}